@@ -11,7 +11,7 @@ var (
 )
 
 type RecyclableChan struct {
-	closed  bool
+	closed  atomic.Bool
 	desc    string
 	extData any
 
@@ -28,13 +28,17 @@ type RecyclableChan struct {
 
 	flag1 atomic.Int32
 	flag2 atomic.Int32
+
+	writeClosed atomic.Bool
+	chanClosed  atomic.Bool
 }
 
 func NewRecyclableChan(desc string, maxPktCnt uint32, extData any) *RecyclableChan {
 	return &RecyclableChan{
-		false, desc, extData, make(chan []byte, maxPktCnt),
+		atomic.Bool{}, desc, extData, make(chan []byte, maxPktCnt),
 		nil, nil, false, false, false, nil,
 		atomic.Int32{}, atomic.Int32{},
+		atomic.Bool{}, atomic.Bool{},
 	}
 }
 
@@ -81,8 +85,13 @@ func (c *RecyclableChan) ReadPacket() (b []byte, err error) {
 	return c.readPkt()
 }
 
+// Len 已写入但尚未读取的包数
+func (c *RecyclableChan) Len() int {
+	return len(c.filledChan)
+}
+
 func (c *RecyclableChan) IsClosed() bool {
-	return c.closed
+	return c.closed.Load()
 }
 
 func (c *RecyclableChan) IsReadReady() bool {
@@ -108,7 +117,7 @@ func (c *RecyclableChan) SetFlag2(flag2 int32) {
 //////////////////////////// implementation of io.Writer
 
 func (c *RecyclableChan) Write(data []byte) (n int, err error) {
-	if c.closed {
+	if c.closed.Load() || c.writeClosed.Load() {
 		return 0, io.EOF
 	}
 	if len(c.filledChan) == cap(c.filledChan) {
@@ -140,13 +149,23 @@ func (c *RecyclableChan) Read(b []byte) (n int, err error) {
 //////////////////////////// implementation of io.Closer
 
 func (c *RecyclableChan) Close() error {
-	if c != nil && !c.closed {
-		c.closed = true
+	if c != nil && c.closed.CompareAndSwap(false, true) {
 
 		//buffer := make([]byte, 0, 1)
 		//c.filledChan <- buffer
 
-		close(c.filledChan)
+		c.closeChan()
+	}
+
+	return nil
+}
+
+// CloseWrite 不再写入, 已写入的数据读完后返回io.EOF
+//
+//	(与Write在同一协程中调用)
+func (c *RecyclableChan) CloseWrite() error {
+	if c != nil && c.writeClosed.CompareAndSwap(false, true) {
+		c.closeChan()
 	}
 
 	return nil
@@ -154,8 +173,14 @@ func (c *RecyclableChan) Close() error {
 
 //////////////////////////// private
 
+func (c *RecyclableChan) closeChan() {
+	if c.chanClosed.CompareAndSwap(false, true) {
+		close(c.filledChan)
+	}
+}
+
 func (c *RecyclableChan) preRead() (err error) {
-	if c.closed {
+	if c.closed.Load() {
 		return io.EOF
 	}
 
@@ -166,7 +191,7 @@ func (c *RecyclableChan) preRead() (err error) {
 		}
 	}
 
-	if c.closed {
+	if c.closed.Load() {
 		return io.EOF
 	} else {
 		return nil
@@ -174,13 +199,13 @@ func (c *RecyclableChan) preRead() (err error) {
 }
 
 func (c *RecyclableChan) readPkt() (b []byte, err error) {
-	if c.closed {
+	if c.closed.Load() {
 		return nil, io.EOF
 	}
 
-	buffer := <-c.filledChan
-
-	if c.closed {
+	// 只根据通道是否关闭判断, 避免丢失关闭前已取出的包
+	buffer, ok := <-c.filledChan
+	if !ok {
 		return nil, io.EOF
 	}
 
@@ -189,22 +214,21 @@ func (c *RecyclableChan) readPkt() (b []byte, err error) {
 }
 
 func (c *RecyclableChan) readPkt2Buffer(b []byte) (n int, err error) {
-	if c.closed {
+	if c.closed.Load() {
 		return 0, io.EOF
 	}
 
 	var buffer []byte
 	if c.pendingBuffer == nil {
-		buffer = <-c.filledChan
+		var ok bool
+		if buffer, ok = <-c.filledChan; !ok {
+			return 0, io.EOF
+		}
 	} else {
 		buffer = c.pendingBuffer
 		c.pendingBuffer = nil
 	}
 
-	if c.closed {
-		return 0, io.EOF
-	}
-
 	if len(b) < len(buffer) {
 		if !c.shouldDropWhileError {
 			c.pendingBuffer = buffer
@@ -217,7 +241,7 @@ func (c *RecyclableChan) readPkt2Buffer(b []byte) (n int, err error) {
 }
 
 func (c *RecyclableChan) readStream2Buffer(b []byte) (n int, err error) {
-	if c.closed {
+	if c.closed.Load() {
 		return 0, io.EOF
 	}
 
@@ -225,9 +249,8 @@ func (c *RecyclableChan) readStream2Buffer(b []byte) (n int, err error) {
 	if c.pendingData != nil {
 		buffer = c.pendingData
 	} else {
-		buffer = <-c.filledChan
-
-		if c.closed {
+		var ok bool
+		if buffer, ok = <-c.filledChan; !ok {
 			return 0, io.EOF
 		}
 	}
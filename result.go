@@ -1,7 +1,9 @@
 package base
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 )
 
@@ -131,3 +133,19 @@ var (
 	REMOTE_SYSTEM_ERROR = &result{START.ICode - 11, "远端系统错误", nil}    // remote system error
 	TRY_AGAIN_LATER     = &result{START.ICode - 12, "操作失败,稍后再试", nil} // action failed, try again later
 )
+
+// ContextResult 将context的错误转换为Result
+//
+//	(DeadlineExceeded => ACTION_TIMEOUT, Canceled => ACTION_CANCELED)
+func ContextResult(err error) Result {
+	switch {
+	case err == nil:
+		return SUCCESS
+	case errors.Is(err, context.DeadlineExceeded):
+		return ACTION_TIMEOUT
+	case errors.Is(err, context.Canceled):
+		return ACTION_CANCELED
+	default:
+		return UNKNOWN.AppendErr("context", err)
+	}
+}
@@ -0,0 +1,85 @@
+package spr
+
+import (
+	"context"
+	"errors"
+	"github.com/patstar123/go-base"
+	"net/rpc"
+	"reflect"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// callContext 可被ctx取消/超时的RPC调用
+//
+//	(超时返回ACTION_TIMEOUT, 取消返回ACTION_CANCELED; 放弃等待后reply不会再被修改)
+func callContext(ctx context.Context, cli *rpc.Client, serviceMethod string, args any, reply any) error {
+	if ctx.Done() == nil {
+		return cli.Call(serviceMethod, args, reply)
+	}
+
+	// 放弃等待后应答仍会被解码, 先解码到私有的值, 成功时再复制到reply
+	private, copyOut := privateReply(reply)
+	call := cli.Go(serviceMethod, args, private, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		if call.Error == nil {
+			copyOut()
+		}
+		return call.Error
+	case <-ctx.Done():
+		return base.ContextResult(ctx.Err()).AppendMsg(serviceMethod)
+	}
+}
+
+// privateReply 创建与reply同类型的私有值, copyOut将其复制到reply
+//
+//	(reply不是非空指针时直接使用reply)
+func privateReply(reply any) (private any, copyOut func()) {
+	v := reflect.ValueOf(reply)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return reply, func() {}
+	}
+
+	if msg, ok := reply.(proto.Message); ok {
+		value := msg.ProtoReflect().New().Interface()
+		return value, func() {
+			proto.Reset(msg)
+			proto.Merge(msg, value)
+		}
+	}
+	value := reflect.New(v.Type().Elem())
+	return value.Interface(), func() {
+		v.Elem().Set(value.Elem())
+	}
+}
+
+// callAsync 异步RPC调用, 结果通过callback通知
+func callAsync(cli *rpc.Client, serviceMethod string, args any, reply any, callback base.Callback) {
+	call := cli.Go(serviceMethod, args, reply, make(chan *rpc.Call, 1))
+	go func() {
+		<-call.Done
+		callback.On(callResult(serviceMethod, call.Error))
+	}()
+}
+
+// callResult 将RPC调用的错误转换为Result
+func callResult(serviceMethod string, err error) base.Result {
+	if err == nil {
+		return base.SUCCESS
+	}
+
+	var res base.Result
+	if errors.As(err, &res) {
+		return res
+	}
+	if errors.Is(err, rpc.ErrShutdown) {
+		return base.TRY_AGAIN_LATER.AppendErr(serviceMethod, err)
+	}
+	return base.REMOTE_SYSTEM_ERROR.AppendErr(serviceMethod, err)
+}
+
+func isTimeout(err error) bool {
+	var res base.Result
+	return errors.As(err, &res) && res.IsEqual(base.ACTION_TIMEOUT)
+}
@@ -0,0 +1,93 @@
+package spr
+
+import (
+	"github.com/patstar123/go-base"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestCallAbandonedReply(t *testing.T) {
+	for _, codec := range []Codec{CodecGob, CodecProto} {
+		fake, caller := startFake(t, codec)
+		fake.SetDelay(100 * time.Millisecond)
+
+		// 超时后到达的应答不修改reply
+		value := -1
+		square := wrapperspb.Int64(-1)
+		var err error
+		if codec == CodecProto {
+			err = caller.CallTimeout("svr.Square", wrapperspb.Int64(3), square, 20*time.Millisecond)
+		} else {
+			err = caller.CallTimeout("svr.Multiply", &TestArgs{3, 4}, &value, 20*time.Millisecond)
+		}
+		if !isTimeout(err) {
+			t.Fatal(codec, "expect timeout:", err)
+		}
+		time.Sleep(200 * time.Millisecond)
+		if value != -1 || square.Value != -1 {
+			t.Fatal(codec, "reply modified:", value, square)
+		}
+
+		fake.SetDelay(0)
+		time.Sleep(100 * time.Millisecond)
+		if codec == CodecProto {
+			err = caller.CallTimeout("svr.Square", wrapperspb.Int64(3), square, time.Second)
+		} else {
+			err = caller.CallTimeout("svr.Multiply", &TestArgs{3, 4}, &value, time.Second)
+		}
+		if err != nil || (value != 12 && square.Value != 9) {
+			t.Fatal(codec, "call:", err, value, square)
+		}
+	}
+}
+
+func TestCallAsync(t *testing.T) {
+	_, caller := startFake(t, CodecGob)
+
+	var value int
+	done := make(chan base.Result, 1)
+	caller.CallAsync("svr.Multiply", &TestArgs{3, 4}, &value, func(res base.Result) { done <- res })
+	select {
+	case res := <-done:
+		if !res.IsOk() || value != 12 {
+			t.Fatal("async:", res, value)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no callback")
+	}
+
+	caller.CallAsync("svr.Unknown", &TestArgs{}, &value, func(res base.Result) { done <- res })
+	if res := <-done; !res.IsEqual(base.REMOTE_SYSTEM_ERROR) {
+		t.Fatal("unknown method:", res)
+	}
+}
+
+func TestCallTimeoutTeardown(t *testing.T) {
+	events := make(chan *ChildEvent, 16)
+	caller := (&SubProcCaller{}).SetCloseOnTimeout(true).
+		SetEventCallback(func(event *ChildEvent) { events <- event })
+	startChild(t, caller, "child", nil)
+
+	var pid, reply int
+	caller.Call("ctl.Pid", 0, &pid)
+	if err := caller.CallTimeout("ctl.Sleep", 300, &reply, 20*time.Millisecond); !isTimeout(err) {
+		t.Fatal("expect timeout:", err)
+	}
+
+	// 仅断开连接: 重新连接后子进程仍可调用
+	var again int
+	if err := caller.CallTimeout("ctl.Pid", 0, &again, time.Second); err != nil || again != pid {
+		t.Fatal("after reconnect:", err, again, pid)
+	}
+
+	// 开启后杀死子进程
+	caller.SetKillOnTimeout(true)
+	if err := caller.CallTimeout("ctl.Sleep", 300, &reply, 20*time.Millisecond); !isTimeout(err) {
+		t.Fatal("expect timeout:", err)
+	}
+	if event := waitEvent(t, events, ChildExited); event.Expected {
+		t.Fatal("exit event:", event)
+	}
+}
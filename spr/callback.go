@@ -1,19 +1,52 @@
 package spr
 
 import (
+	"context"
 	"errors"
-	"fmt"
 	"github.com/livekit/protocol/logger"
 	"github.com/patstar123/go-base"
+	"github.com/patstar123/go-base/utils"
 	"net"
 	"net/rpc"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
 )
 
 // SubProcCBListener 子进程回调监听器
 type SubProcCBListener struct {
-	name     string
-	listener net.Listener
-	port     int
+	name      string
+	listener  net.Listener
+	port      int
+	addr      string
+	cleanup   func()
+	transport Transport
+	codec     Codec
+
+	authMutex    sync.Mutex
+	tokens       map[string] /*childName*/ string // 每个子进程独立的握手密钥
+	authFailures atomic.Int64
+
+	services map[string] /*rpcObjName*/ RpcSvr
+
+	subMutex    sync.Mutex
+	subscribers map[eventKey][]EventHandler
+}
+
+var gListeners = map[string] /*addr*/ *SubProcCBListener{}
+
+// SetTransport 设置回调监听的传输方式(默认TransportTCP)
+func (l *SubProcCBListener) SetTransport(transport Transport) *SubProcCBListener {
+	l.transport = transport
+	return l
+}
+
+// SetCodec 设置回调的编解码方式(默认CodecGob)
+func (l *SubProcCBListener) SetCodec(codec Codec) *SubProcCBListener {
+	l.codec = codec
+	return l
 }
 
 func (l *SubProcCBListener) StartLoop(name string, rpcObjs map[string] /*rpcObjName*/ RpcSvr) base.Result {
@@ -26,39 +59,50 @@ func (l *SubProcCBListener) StartLoop(name string, rpcObjs map[string] /*rpcObjN
 	l.name = name
 	l.port = -1
 
-	// 查找可用callback端口
-	port := findAvailablePort()
-	if port <= 0 {
-		res := base.INTERNAL_ERROR.AppendMsg("has no available callback port")
+	// 分配callback地址
+	cbAddr, cleanup, res := newAddress(l.transport, "cb")
+	if !res.IsOk() {
+		res = res.AppendMsg("has no available callback address")
 		logger.Warnw("[cbSvr]StartLoop failed for "+l.name, res)
 		return res
 	}
-	cbPort := fmt.Sprintf("%v", port)
 
-	if debugEnabled {
-		cbPort = debugCallbackPort
+	// 注册回调数据类型, 并检查回调方法
+	//	(每个子进程连接使用独立的rpc.Server, 服务按调用方绑定)
+	services := map[string]RpcSvr{}
+	for rpcName, rpcSvr := range rpcObjs {
+		LoadRpcTypes(rpcSvr)
+		services[rpcName] = rpcSvr
 	}
-
-	// 注册回调方法和数据类型
-	if rpcObjs != nil {
-		for rpcName, rpcSvr := range rpcObjs {
-			LoadRpcTypes(rpcSvr)
-			rpc.RegisterName(rpcName, rpcSvr)
-		}
+	if err := registerServices(rpc.NewServer(), services, ""); err != nil {
+		cleanup()
+		res := base.INVALID_PARAM.AppendErr("register callback service failed", err)
+		logger.Errorw("[cbSvr]StartLoop failed", res)
+		return res
 	}
 
-	listener, err := net.Listen("tcp", "127.0.0.1:"+cbPort)
+	listener, err := listenAddress(cbAddr)
 	if err != nil {
+		cleanup()
 		res := base.INVALID_PARAM.AppendErr("start callback listener failed", err)
 		logger.Errorw("[cbSvr]StartLoop failed", res)
 		return res
 	}
 
 	l.listener = listener
-	l.port = port
+	l.addr = cbAddr
+	l.cleanup = cleanup
+	l.services = services
+	codec := l.codec
+	gMutex.Lock()
+	gListeners[cbAddr] = l
+	gMutex.Unlock()
+	if port, err := strconv.Atoi(cbAddr); err == nil {
+		l.port = port
+	}
 
 	go func() {
-		logger.Infow("[cbSvr]" + l.name + " listening on 127.0.0.1:" + cbPort)
+		logger.Infow("[cbSvr]" + name + " listening on " + displayAddress(cbAddr))
 		for {
 			conn, err := listener.Accept()
 			if err != nil {
@@ -70,47 +114,175 @@ func (l *SubProcCBListener) StartLoop(name string, rpcObjs map[string] /*rpcObjN
 				continue
 			}
 
-			go rpc.ServeConn(conn)
+			go func() {
+				// 子进程名须与其密钥匹配, 避免冒充其他子进程(CallerBinder按子进程名绑定)
+				if req, ok := acceptHandshake("[cbSvr]", conn, l.tokenOf, codec, false, &l.authFailures); ok {
+					l.serveConn(conn, req.Name, services, codec)
+				}
+			}()
 		}
-		logger.Infow("[cbSvr]" + l.name + " exited")
+		logger.Infow("[cbSvr]" + name + " exited")
 	}()
 
 	return base.SUCCESS
 }
 
+// serveConn 为子进程的回调连接创建独立的rpc.Server
+func (l *SubProcCBListener) serveConn(conn net.Conn, childName string, services map[string]RpcSvr, codec Codec) {
+	// 回调连接断开后子进程不再使用该密钥
+	defer l.revokeToken(childName, l.tokenOf(childName))
+
+	server := rpc.NewServer()
+	events := newEventService(l, childName)
+	// ServeCodec在进行中的调用都返回后才返回
+	defer events.close()
+	server.RegisterName(eventServiceName, events)
+	if err := registerServices(server, services, childName); err != nil {
+		logger.Warnw("[cbSvr]register callback service failed", err, "child", childName)
+		conn.Close()
+		return
+	}
+	server.ServeCodec(newServerCodec(codec, conn))
+}
+
 func (l *SubProcCBListener) StopLoop() {
 	if l.listener != nil {
+		gMutex.Lock()
+		delete(gListeners, l.addr)
+		gMutex.Unlock()
+
 		l.listener.Close()
+		l.cleanup()
 		l.listener = nil
 		l.name = ""
 		l.port = -1
+		l.addr = ""
+		l.cleanup = nil
+
+		l.authMutex.Lock()
+		l.tokens = nil
+		l.authMutex.Unlock()
+	}
+}
+
+// AuthFailures 握手校验失败的连接数
+func (l *SubProcCBListener) AuthFailures() int64 {
+	return l.authFailures.Load()
+}
+
+// issueListenerToken 为子进程签发本进程内监听在addr上的回调监听器的握手密钥, 并返回编解码方式
+//
+//	(每次启动子进程时重新签发, 同名子进程之前的密钥随之失效)
+func issueListenerToken(addr, childName string) (string, Codec) {
+	gMutex.Lock()
+	l, ok := gListeners[addr]
+	gMutex.Unlock()
+	if !ok {
+		return "", CodecGob
+	}
+
+	token := utils.RandomSecret()
+	l.authMutex.Lock()
+	defer l.authMutex.Unlock()
+	if l.tokens == nil {
+		l.tokens = map[string]string{}
+	}
+	l.tokens[childName] = token
+	return token, l.codec
+}
+
+// revokeListenerToken 收回issueListenerToken签发的密钥(子进程退出时调用)
+//
+//	(已被重新签发时保留新的密钥)
+func revokeListenerToken(addr, childName, token string) {
+	gMutex.Lock()
+	l, ok := gListeners[addr]
+	gMutex.Unlock()
+	if ok {
+		l.revokeToken(childName, token)
 	}
 }
 
+func (l *SubProcCBListener) revokeToken(childName, token string) {
+	l.authMutex.Lock()
+	defer l.authMutex.Unlock()
+	if token != "" && l.tokens[childName] == token {
+		delete(l.tokens, childName)
+	}
+}
+
+func (l *SubProcCBListener) tokenOf(childName string) string {
+	l.authMutex.Lock()
+	defer l.authMutex.Unlock()
+	return l.tokens[childName]
+}
+
+// GetPort 回调监听的TCP端口(非TCP传输方式时为-1)
 func (l *SubProcCBListener) GetPort() int {
 	return l.port
 }
 
+// GetAddress 回调监听的地址(TCP为端口号, Unix为"unix:"+套接字路径)
+func (l *SubProcCBListener) GetAddress() string {
+	return l.addr
+}
+
 // SubProcCallback 子进程回调器
 type SubProcCallback struct {
-	cli *rpc.Client
+	mutex          sync.Mutex
+	cli            *rpc.Client
+	lost           chan struct{}
+	closeOnTimeout bool
+	name           string
+	token          string
+	codec          Codec
 }
 
 type CallbackTyper = RunnerTyper
 
-func (c *SubProcCallback) ConnectListener(cbPort string, typers []CallbackTyper) base.Result {
+// SetAuth 设置连接回调监听器时握手使用的子进程名和密钥
+func (c *SubProcCallback) SetAuth(name, token string) *SubProcCallback {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.name = name
+	c.token = token
+	return c
+}
+
+// SetCodec 设置回调的编解码方式, 须与回调监听器一致(默认CodecGob)
+func (c *SubProcCallback) SetCodec(codec Codec) *SubProcCallback {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.codec = codec
+	return c
+}
+
+// ConnectListener 连接回调监听器, cbAddr为端口号或"unix:"+套接字路径
+func (c *SubProcCallback) ConnectListener(cbAddr string, typers []CallbackTyper) base.Result {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	if c.cli != nil {
 		res := base.LOGICAL_ERROR.AppendMsg("has been connected")
 		logger.Errorw("[cbCli]ConnectListener failed", res)
 		return res
 	}
 
-	client, err := rpc.Dial("tcp", "localhost:"+cbPort)
+	conn, err := dialAddress(cbAddr)
 	if err != nil {
 		res := base.INTERNAL_ERROR.AppendErr("connect callback listener failed", err)
 		logger.Warnw("[cbCli]ConnectListener failed", res)
 		return res
 	}
+	err = clientHandshake(conn, &handshakeReq{Token: c.token, Name: c.name, Codec: c.codec.String()})
+	if err != nil {
+		conn.Close()
+		res := base.ACTION_ILLEGAL.AppendErr("callback listener handshake failed", err)
+		logger.Warnw("[cbCli]ConnectListener failed", res)
+		return res
+	}
+	c.lost = make(chan struct{})
+	client := newClient(c.codec, &lostConn{Conn: conn, lost: c.lost})
 
 	if typers != nil {
 		for _, typer := range typers {
@@ -122,7 +294,15 @@ func (c *SubProcCallback) ConnectListener(cbPort string, typers []CallbackTyper)
 	return base.SUCCESS
 }
 
+// Lost 回调连接断开时关闭的通道
+func (c *SubProcCallback) Lost() <-chan struct{} {
+	return c.lost
+}
+
 func (c *SubProcCallback) Disconnect() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	if c.cli != nil {
 		c.cli.Close()
 		c.cli = nil
@@ -130,11 +310,92 @@ func (c *SubProcCallback) Disconnect() {
 }
 
 func (c *SubProcCallback) Call(serviceMethod string, args any, reply any) error {
-	if c.cli == nil {
+	cli, err := c.client()
+	if err != nil {
+		return err
+	}
+
+	return cli.Call(serviceMethod, args, reply)
+}
+
+// CallContext 可被ctx取消/超时的回调
+//
+//	(超时返回ACTION_TIMEOUT, 取消返回ACTION_CANCELED)
+func (c *SubProcCallback) CallContext(ctx context.Context, serviceMethod string, args any, reply any) error {
+	cli, err := c.client()
+	if err != nil {
+		return err
+	}
+
+	err = callContext(ctx, cli, serviceMethod, args, reply)
+	if isTimeout(err) {
+		c.mutex.Lock()
+		if c.closeOnTimeout && c.cli == cli {
+			logger.Warnw("[cbCli]call timeout, disconnect", nil, "method", serviceMethod)
+			c.cli.Close()
+			c.cli = nil
+		}
+		c.mutex.Unlock()
+	}
+	return err
+}
+
+// CallTimeout 带超时的回调
+func (c *SubProcCallback) CallTimeout(serviceMethod string, args any, reply any, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return c.CallContext(ctx, serviceMethod, args, reply)
+}
+
+// CallAsync 异步回调, 结果通过callback通知
+func (c *SubProcCallback) CallAsync(serviceMethod string, args any, reply any, callback base.Callback) {
+	cli, err := c.client()
+	if err != nil {
+		callback.On(callResult(serviceMethod, err))
+		return
+	}
+
+	callAsync(cli, serviceMethod, args, reply, callback)
+}
+
+// SetCloseOnTimeout 设置回调超时后是否断开回调连接
+func (c *SubProcCallback) SetCloseOnTimeout(close bool) *SubProcCallback {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closeOnTimeout = close
+	return c
+}
+
+func (c *SubProcCallback) client() (*rpc.Client, error) {
+	c.mutex.Lock()
+	cli := c.cli
+	c.mutex.Unlock()
+
+	if cli == nil {
 		res := base.LOGICAL_ERROR.AppendMsg("connect first")
 		logger.Errorw("[cbCli]Call failed", res)
-		return res
+		return nil, res
 	}
+	return cli, nil
+}
+
+// lostConn 连接被动断开(非本端关闭)时关闭lost通道
+type lostConn struct {
+	net.Conn
+	lost   chan struct{}
+	once   sync.Once
+	closed atomic.Bool
+}
+
+func (c *lostConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil && !c.closed.Load() {
+		c.once.Do(func() { close(c.lost) })
+	}
+	return n, err
+}
 
-	return c.cli.Call(serviceMethod, args, reply)
+func (c *lostConn) Close() error {
+	c.closed.Store(true)
+	return c.Conn.Close()
 }
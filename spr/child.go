@@ -1,6 +1,8 @@
 package spr
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"github.com/livekit/protocol/logger"
 	"github.com/patstar123/go-base"
@@ -8,95 +10,172 @@ import (
 	"net"
 	"net/rpc"
 	"os"
+	"sort"
 	"strconv"
+	"sync"
 	"time"
+
+	"go.uber.org/atomic"
 )
 
 // SubProcRunner 子进程执行器
 type SubProcRunner struct {
+	mutex    sync.Mutex
 	listener net.Listener
 	callback *SubProcCallback
+	lastPing atomic.Int64
+	exitRes  base.Result
+	stopping bool
+	stopped  chan struct{} // StopLoop时关闭
+	ctx      *RunnerContext
+	hook     ShutdownHook
+	codec    *Codec
+
+	onStream     StreamHandler
+	streamWindow int
+	events       *eventQueue
+	inFlight     atomic.Int64
+	leftover     atomic.Int64 // 优雅退出超时后未完成的调用数(-1表示尚未完成等待)
+
+	authFailures atomic.Int64
+	methods      []string // 已注册的服务方法(用于RpcHello)
+
+	// wrapCodec 包装每条RPC连接的编解码(FakeRunner用于注入故障)
+	wrapCodec func(conn net.Conn, codec rpc.ServerCodec) rpc.ServerCodec
+}
+
+// SetCodec 设置RPC服务的编解码方式
+//
+//	(未设置时使用父进程通过环境变量SPR_CODEC指定的方式; 与父进程不一致时握手失败)
+func (c *SubProcRunner) SetCodec(codec Codec) *SubProcRunner {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.codec = &codec
+	return c
+}
+
+// ShutdownHook 子进程优雅退出钩子, drained表示进行中的调用是否已在期限内全部完成
+type ShutdownHook func(drained bool)
+
+// SetShutdownHook 设置父进程通知优雅退出时执行的钩子
+//
+//	(在进行中的调用完成或超过期限后执行, 执行完成后才应答父进程)
+func (c *SubProcRunner) SetShutdownHook(hook ShutdownHook) *SubProcRunner {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.hook = hook
+	return c
 }
 
 func (c *SubProcRunner) Run(rpcObjs map[string] /*rpcObjName*/ RpcSvr, cbTypes []CallbackTyper) base.Result {
+	ctx, res := ParseRunnerContext(os.Args)
+	if !res.IsOk() {
+		logger.Errorw("[child]Run failed", res)
+		return res
+	}
+
+	return c.RunWithContext(ctx, rpcObjs, cbTypes)
+}
+
+// RunWithContext 以已解析的启动上下文运行
+//
+//	(应用需要在运行前读取自定义参数时, 先调用ParseRunnerContext(os.Args))
+func (c *SubProcRunner) RunWithContext(ctx *RunnerContext, rpcObjs map[string] /*rpcObjName*/ RpcSvr,
+	cbTypes []CallbackTyper) base.Result {
 	utils.SetAbortCallback(func() { c.StopLoop() })
 	utils.ListenAbortSignal()
 	defer utils.ListenPanic(true)
 
-	if c.listener != nil {
+	c.mutex.Lock()
+	running := c.listener != nil
+	c.mutex.Unlock()
+	if running {
 		res := base.LOGICAL_ERROR.AppendMsg("has been running")
 		logger.Errorw("[child]Run failed", res)
 		return res
 	}
 
-	if len(os.Args) < 2 {
-		res := base.INVALID_PARAM.AppendMsg("lost command params: child name")
-		logger.Errorw("[child]Run failed", res)
-		return res
-	}
-	if len(os.Args) < 3 {
-		res := base.INVALID_PARAM.AppendMsg("lost command params: log level")
-		logger.Errorw("[child]Run failed", res)
-		return res
-	}
-	if len(os.Args) < 4 {
-		res := base.INVALID_PARAM.AppendMsg("lost command params: rpc port")
-		logger.Errorw("[child]Run failed", res)
-		return res
-	}
+	logger.SetLogLevel(ctx.LogLevel)
+	return c.serve(ctx, rpcObjs, cbTypes)
+}
+
+// Context 子进程的启动上下文(Run之后有效)
+func (c *SubProcRunner) Context() *RunnerContext {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ctx
+}
 
-	cbPort := ""
-	if len(os.Args) >= 5 {
-		cbPort = os.Args[4]
+func (c *SubProcRunner) serve(ctx *RunnerContext, rpcObjs map[string] /*rpcObjName*/ RpcSvr,
+	cbTypes []CallbackTyper) base.Result {
+	name := ctx.Name
+	c.mutex.Lock()
+	c.ctx = ctx
+	codec := ctx.Codec
+	if c.codec != nil {
+		codec = *c.codec
 	}
+	streams := c.onStream != nil
+	wrapCodec := c.wrapCodec
+	c.mutex.Unlock()
 
-	name := os.Args[1]
-	logger.SetLogLevel(os.Args[2])
-	rpcPort := os.Args[3]
-	_, err := strconv.Atoi(rpcPort)
-	if err != nil {
-		res := base.INVALID_PARAM.AppendErr("invalid rpc port: "+rpcPort, err)
+	server := rpc.NewServer()
+	server.RegisterName(BaseChildName, &BaseChild{handler: c})
+	methods := serviceMethods(BaseChildName, &BaseChild{})
+	for rpcName, rpcSvr := range rpcObjs {
+		LoadRpcTypes(rpcSvr)
+		methods = append(methods, serviceMethods(rpcName, rpcSvr)...)
+	}
+	sort.Strings(methods)
+	if err := registerServices(server, rpcObjs, ""); err != nil {
+		res := base.INVALID_PARAM.AppendErr("register rpc service failed", err)
 		logger.Errorw("[child]Run failed", res)
 		return res
 	}
-	if cbPort != "" {
-		_, err = strconv.Atoi(cbPort)
-		if err != nil {
-			res := base.INVALID_PARAM.AppendErr("invalid callback port: "+cbPort, err)
-			logger.Errorw("[child]Run failed", res)
-			return res
-		}
-	}
-
-	rpc.RegisterName(BaseChildName, &BaseChild{c})
-	if rpcObjs != nil {
-		for rpcName, rpcSvr := range rpcObjs {
-			LoadRpcTypes(rpcSvr)
-			rpc.RegisterName(rpcName, rpcSvr)
-		}
-	}
 
-	listener, err := net.Listen("tcp", "127.0.0.1:"+rpcPort)
+	listener, err := listenAddress(ctx.RpcAddr)
 	if err != nil {
 		res := base.INVALID_PARAM.AppendErr("start rpc server failed", err)
 		logger.Errorw("[child]Run failed", res)
 		return res
 	}
+	stopped := make(chan struct{})
+	c.mutex.Lock()
 	c.listener = listener
+	c.methods = methods
+	c.exitRes = base.SUCCESS
+	c.stopping = false
+	c.stopped = stopped
+	c.leftover.Store(-1)
+	c.mutex.Unlock()
 
-	if cbPort != "" {
-		c.callback = &SubProcCallback{}
+	done := make(chan struct{})
+	defer close(done)
+
+	if ctx.CallbackAddr != "" {
+		callback := (&SubProcCallback{}).SetAuth(name, ctx.cbToken).SetCodec(ctx.CallbackCodec)
+		c.mutex.Lock()
+		c.callback = callback
+		c.mutex.Unlock()
 		go func() {
-			res := c.callback.ConnectListener(cbPort, cbTypes)
+			res := callback.ConnectListener(ctx.CallbackAddr, cbTypes)
 			if res.IsOk() {
 				logger.Infow("[child]" + name + " connected to callback listener")
+				go c.sendEvents(callback, done)
+				c.watchCallback(callback, done)
 			} else {
 				logger.Warnw("[child]"+name+" connect to callback listener failed", res)
+				c.getEvents().kill("connect to callback listener failed")
 			}
 		}()
 	}
 
-	logger.Infow("[child]" + name + " listening on 127.0.0.1:" + rpcPort)
+	if timeout := ctx.parentTimeoutValue(); timeout > 0 {
+		c.lastPing.Store(time.Now().UnixNano())
+		go c.watchPing(timeout, done)
+	}
+
+	logger.Infow("[child]" + name + " listening on " + displayAddress(ctx.RpcAddr))
 
 	for {
 		conn, err := listener.Accept()
@@ -109,52 +188,284 @@ func (c *SubProcRunner) Run(rpcObjs map[string] /*rpcObjName*/ RpcSvr, cbTypes [
 			continue
 		}
 
-		go rpc.ServeConn(conn)
+		go func() {
+			tokenOf := func(string) string { return ctx.token }
+			req, ok := acceptHandshake("[child]", conn, tokenOf, codec, streams, &c.authFailures)
+			if !ok {
+				return
+			}
+			if req.Stream != "" {
+				c.acceptStream(req.Stream, conn)
+			} else {
+				serverCodec := newServerCodec(codec, conn)
+				if wrapCodec != nil {
+					serverCodec = wrapCodec(conn, serverCodec)
+				}
+				server.ServeCodec(&trackedCodec{serverCodec, &c.inFlight})
+			}
+		}()
 	}
 
+	c.waitDrained(stopped)
+	c.StopLoop()
 	logger.Infow("[child]" + name + " exited")
-	return base.SUCCESS
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.exitRes
+}
+
+// AuthFailures 握手校验失败的连接数
+func (c *SubProcRunner) AuthFailures() int64 {
+	return c.authFailures.Load()
+}
+
+// InFlight 进行中的调用数
+func (c *SubProcRunner) InFlight() int64 {
+	return c.inFlight.Load()
 }
 
 func (c *SubProcRunner) StopLoop() {
-	if c.listener != nil {
-		c.listener.Close()
-		c.listener = nil
+	c.mutex.Lock()
+	listener, callback, stopped := c.listener, c.callback, c.stopped
+	c.listener = nil
+	c.callback = nil
+	c.stopped = nil
+	c.mutex.Unlock()
+
+	if stopped != nil {
+		close(stopped)
+	}
+
+	if listener != nil {
+		listener.Close()
 	}
-	if c.callback != nil {
-		c.callback.Disconnect()
-		c.callback = nil
+	if callback != nil {
+		callback.Disconnect()
 	}
 }
 
 func (c *SubProcRunner) Callback(cbMethod string, args any, reply any) error {
-	if c.callback == nil {
+	callback, err := c.callbackCli()
+	if err != nil {
+		return err
+	}
+
+	return callback.Call(cbMethod, args, reply)
+}
+
+// CallbackContext 可被ctx取消/超时的回调
+func (c *SubProcRunner) CallbackContext(ctx context.Context, cbMethod string, args any, reply any) error {
+	callback, err := c.callbackCli()
+	if err != nil {
+		return err
+	}
+
+	return callback.CallContext(ctx, cbMethod, args, reply)
+}
+
+// CallbackTimeout 带超时的回调
+func (c *SubProcRunner) CallbackTimeout(cbMethod string, args any, reply any, timeout time.Duration) error {
+	callback, err := c.callbackCli()
+	if err != nil {
+		return err
+	}
+
+	return callback.CallTimeout(cbMethod, args, reply, timeout)
+}
+
+// CallbackAsync 异步回调, 结果通过callback通知
+func (c *SubProcRunner) CallbackAsync(cbMethod string, args any, reply any, callback base.Callback) {
+	cbCli, err := c.callbackCli()
+	if err != nil {
+		callback.On(callResult(cbMethod, err))
+		return
+	}
+
+	cbCli.CallAsync(cbMethod, args, reply, callback)
+}
+
+func (c *SubProcRunner) callbackCli() (*SubProcCallback, error) {
+	c.mutex.Lock()
+	callback := c.callback
+	c.mutex.Unlock()
+
+	if callback == nil {
 		res := base.LOGICAL_ERROR.AppendMsg("parent not set callback port")
 		logger.Errorw("[child]Callback failed", res)
-		return res
+		return nil, res
 	}
+	return callback, nil
+}
 
-	return c.callback.Call(cbMethod, args, reply)
+// watchCallback 回调连接断开即判定父进程丢失
+func (c *SubProcRunner) watchCallback(callback *SubProcCallback, done chan struct{}) {
+	select {
+	case <-done:
+	case <-callback.Lost():
+		c.mutex.Lock()
+		current := c.callback
+		c.mutex.Unlock()
+		if current == callback {
+			c.onParentLost("callback connection dropped")
+		}
+	}
+}
+
+// watchPing 超时未收到心跳即判定父进程丢失
+func (c *SubProcRunner) watchPing(timeout time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(timeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if now.Sub(time.Unix(0, c.lastPing.Load())) > timeout {
+				c.onParentLost("no ping in " + timeout.String())
+				return
+			}
+		}
+	}
+}
+
+func (c *SubProcRunner) onParentLost(reason string) {
+	c.mutex.Lock()
+	if c.stopping {
+		c.mutex.Unlock()
+		return
+	}
+	res := base.REMOTE_SYSTEM_ERROR.AppendMsg("parent lost: " + reason)
+	c.exitRes = res
+	c.mutex.Unlock()
+
+	logger.Warnw("[child]exit itself", res)
+	c.StopLoop()
+}
+
+// drain 优雅退出: 停止接受新连接, 在deadline内等待进行中的调用完成, 然后执行退出钩子
+//
+//	(由停止请求调用, 返回未完成的调用数)
+func (c *SubProcRunner) drain(deadline time.Duration) int {
+	c.mutex.Lock()
+	c.stopping = true
+	listener, hook := c.listener, c.hook
+	c.listener = nil
+	c.mutex.Unlock()
+
+	if listener != nil {
+		listener.Close()
+	}
+
+	// 不计入停止请求本身
+	until := time.Now().Add(deadline)
+	for c.inFlight.Load() > 1 && time.Now().Before(until) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	remaining := int(c.inFlight.Load() - 1)
+	c.flushEvents(until)
+	if remaining > 0 {
+		logger.Warnw("[child]drain timeout", nil, "remaining", remaining, "deadline", deadline)
+	} else {
+		logger.Infow("[child]drained")
+	}
+
+	if hook != nil {
+		hook(remaining <= 0)
+	}
+	remaining = max(remaining, 0)
+	c.leftover.Store(int64(remaining))
+	return remaining
+}
+
+// waitDrained 优雅退出时等待停止请求完成应答, StopLoop可中断等待
+//
+//	(超过期限仍未完成的调用不再等待)
+func (c *SubProcRunner) waitDrained(stopped chan struct{}) {
+	c.mutex.Lock()
+	stopping := c.stopping
+	c.mutex.Unlock()
+	if !stopping {
+		return
+	}
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		leftover := c.leftover.Load()
+		if leftover >= 0 && c.inFlight.Load() <= leftover {
+			return
+		}
+		select {
+		case <-stopped:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (ctx *RunnerContext) parentTimeoutValue() time.Duration {
+	value := ctx.parentTimeout
+	if value == "" {
+		return 0
+	}
+	ms, err := strconv.Atoi(value)
+	if err != nil || ms <= 0 {
+		logger.Warnw("[child]invalid "+envParentTimeout+": "+value, err)
+		return 0
+	}
+	return time.Duration(ms) * time.Millisecond
 }
 
 type BaseChild struct {
-	handler *SubProcRunner
+	handler  *SubProcRunner // 注册后不再修改, 可被并发的调用读取
+	stopping atomic.Bool    // 已收到优雅退出请求
 }
 
+// RpcStopChild 优雅退出, args为等待进行中调用完成的期限(ms), reply为未完成的调用数
+//
+//	(仅第一次请求生效)
 func (c *BaseChild) RpcStopChild(args int, reply *int) error {
-	if c.handler != nil {
-		handler := c.handler
-		c.handler = nil
-		go func() {
-			time.Sleep(10 * time.Millisecond)
-			handler.StopLoop()
-		}()
-	}
 	*reply = 0
+	if c.handler != nil && c.stopping.CompareAndSwap(false, true) {
+		*reply = c.handler.drain(time.Duration(args) * time.Millisecond)
+	}
 	return nil
 }
 
+// RpcPing 心跳, 退出过程中reply为false
 func (c *BaseChild) RpcPing(args int, reply *bool) error {
-	*reply = c.handler != nil
+	alive := c.handler != nil && !c.stopping.Load()
+	if alive {
+		c.handler.lastPing.Store(time.Now().UnixNano())
+	}
+	*reply = alive
+	return nil
+}
+
+// RpcRuntimeStats 子进程的Go运行时状态, reply为JSON编码的RuntimeStats(兼容所有编解码方式)
+func (c *BaseChild) RpcRuntimeStats(args int, reply *[]byte) error {
+	data, err := json.Marshal(currentRuntimeStats())
+	if err != nil {
+		return err
+	}
+	*reply = data
+	return nil
+}
+
+// RpcHello 子进程的版本和能力, args为父进程的协议版本, reply为JSON编码的RunnerHello
+func (c *BaseChild) RpcHello(args int, reply *[]byte) error {
+	hello := &RunnerHello{Building: utils.AppBuilding, Protocol: ProtocolVersion}
+	if handler := c.handler; handler != nil {
+		// 退出过程中仍如实报告可调用的方法
+		handler.mutex.Lock()
+		hello.Methods = handler.methods
+		handler.mutex.Unlock()
+	}
+	data, err := json.Marshal(hello)
+	if err != nil {
+		return err
+	}
+	*reply = data
 	return nil
 }
@@ -0,0 +1,383 @@
+package spr
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"sync"
+
+	"go.uber.org/atomic"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec RPC编解码方式
+//
+//	(在连接握手时校验, 两端不一致时握手失败)
+type Codec int
+
+const (
+	CodecGob   Codec = iota // net/rpc默认的gob编码(默认), 自定义类型需通过CustomTypeValues注册
+	CodecJSON               // net/rpc/jsonrpc(JSON-RPC 1.0), 便于用其他语言实现子进程
+	CodecProto              // 长度前缀的protobuf帧, 参数和返回值须为proto.Message
+)
+
+var codecNames = []string{"gob", "json", "proto"}
+
+func (c Codec) String() string {
+	if c >= 0 && int(c) < len(codecNames) {
+		return codecNames[c]
+	}
+	return "unknown"
+}
+
+// ParseCodec 按名称解析编解码方式, 空名称表示CodecGob
+func ParseCodec(name string) (Codec, error) {
+	if name == "" {
+		return CodecGob, nil
+	}
+	for i, n := range codecNames {
+		if n == name {
+			return Codec(i), nil
+		}
+	}
+	return CodecGob, errors.New("unknown codec: " + name)
+}
+
+//////////////////////////////////// private functions
+
+func newServerCodec(codec Codec, conn io.ReadWriteCloser) rpc.ServerCodec {
+	switch codec {
+	case CodecJSON:
+		return jsonrpc.NewServerCodec(conn)
+	case CodecProto:
+		return newProtoCodec(conn)
+	default:
+		return newGobServerCodec(conn)
+	}
+}
+
+func newClient(codec Codec, conn io.ReadWriteCloser) *rpc.Client {
+	switch codec {
+	case CodecJSON:
+		return jsonrpc.NewClient(conn)
+	case CodecProto:
+		return rpc.NewClientWithCodec(newProtoCodec(conn))
+	default:
+		return rpc.NewClient(conn)
+	}
+}
+
+// gobServerCodec 与net/rpc默认使用的gob编解码一致
+type gobServerCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	closed bool
+}
+
+func newGobServerCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
+	buf := bufio.NewWriter(conn)
+	return &gobServerCodec{
+		rwc:    conn,
+		dec:    gob.NewDecoder(conn),
+		enc:    gob.NewEncoder(buf),
+		encBuf: buf,
+	}
+}
+
+func (c *gobServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	return c.dec.Decode(r)
+}
+
+func (c *gobServerCodec) ReadRequestBody(body any) error {
+	return c.dec.Decode(body)
+}
+
+func (c *gobServerCodec) WriteResponse(r *rpc.Response, body any) (err error) {
+	if err = c.enc.Encode(r); err != nil {
+		if c.encBuf.Flush() == nil {
+			// gob无法编码响应头, 关闭连接
+			c.Close()
+		}
+		return
+	}
+	if err = c.enc.Encode(body); err != nil {
+		if c.encBuf.Flush() == nil {
+			// gob无法编码响应体, 关闭连接
+			c.Close()
+		}
+		return
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *gobServerCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
+
+// trackedCodec 统计进行中的调用数
+//
+//	(net/rpc对每个读到请求头的调用都会写回一次响应)
+type trackedCodec struct {
+	rpc.ServerCodec
+	inFlight *atomic.Int64
+}
+
+func (c *trackedCodec) ReadRequestHeader(r *rpc.Request) error {
+	err := c.ServerCodec.ReadRequestHeader(r)
+	if err == nil {
+		c.inFlight.Inc()
+	}
+	return err
+}
+
+func (c *trackedCodec) WriteResponse(r *rpc.Response, body any) error {
+	defer c.inFlight.Dec()
+	return c.ServerCodec.WriteResponse(r, body)
+}
+
+// protoCodec 长度前缀的protobuf编解码(同时实现rpc.ServerCodec和rpc.ClientCodec)
+//
+//	. 每个请求/响应由两帧组成: 头部帧 + 消息体帧
+//	. 帧格式: uvarint长度 + 数据
+//	. 头部字段: 1=ServiceMethod(string), 2=Seq(uint64), 3=Error(string)
+//	. 消息体为proto.Message的序列化数据, 出错的响应消息体为空
+//	. 内置方法使用的int/bool/[]byte按字段1编码(与wrapperspb.Int64Value/BoolValue/BytesValue兼容)
+type protoCodec struct {
+	rwc    io.ReadWriteCloser
+	reader *bufio.Reader
+	mutex  sync.Mutex
+	writer *bufio.Writer
+}
+
+type protoHeader struct {
+	ServiceMethod string
+	Seq           uint64
+	Error         string
+}
+
+const protoMaxFrame = 64 * 1024 * 1024
+
+func newProtoCodec(conn io.ReadWriteCloser) *protoCodec {
+	return &protoCodec{rwc: conn, reader: bufio.NewReader(conn), writer: bufio.NewWriter(conn)}
+}
+
+func (c *protoCodec) ReadRequestHeader(r *rpc.Request) error {
+	var h protoHeader
+	if err := c.readHeader(&h); err != nil {
+		return err
+	}
+	r.ServiceMethod, r.Seq = h.ServiceMethod, h.Seq
+	return nil
+}
+
+func (c *protoCodec) ReadRequestBody(body any) error {
+	return c.readBody(body)
+}
+
+func (c *protoCodec) WriteResponse(r *rpc.Response, body any) error {
+	if r.Error != "" {
+		body = nil
+	}
+	return c.write(&protoHeader{ServiceMethod: r.ServiceMethod, Seq: r.Seq, Error: r.Error}, body)
+}
+
+func (c *protoCodec) WriteRequest(r *rpc.Request, body any) error {
+	return c.write(&protoHeader{ServiceMethod: r.ServiceMethod, Seq: r.Seq}, body)
+}
+
+func (c *protoCodec) ReadResponseHeader(r *rpc.Response) error {
+	var h protoHeader
+	if err := c.readHeader(&h); err != nil {
+		return err
+	}
+	r.ServiceMethod, r.Seq, r.Error = h.ServiceMethod, h.Seq, h.Error
+	return nil
+}
+
+func (c *protoCodec) ReadResponseBody(body any) error {
+	return c.readBody(body)
+}
+
+func (c *protoCodec) Close() error {
+	return c.rwc.Close()
+}
+
+func (c *protoCodec) write(h *protoHeader, body any) error {
+	data, err := marshalProtoBody(body)
+	if err != nil {
+		return err
+	}
+
+	var header []byte
+	header = protowire.AppendTag(header, 1, protowire.BytesType)
+	header = protowire.AppendString(header, h.ServiceMethod)
+	header = protowire.AppendTag(header, 2, protowire.VarintType)
+	header = protowire.AppendVarint(header, h.Seq)
+	if h.Error != "" {
+		header = protowire.AppendTag(header, 3, protowire.BytesType)
+		header = protowire.AppendString(header, h.Error)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.writeFrame(header); err != nil {
+		return err
+	}
+	if err := c.writeFrame(data); err != nil {
+		return err
+	}
+	return c.writer.Flush()
+}
+
+func (c *protoCodec) writeFrame(data []byte) error {
+	var size [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(size[:], uint64(len(data)))
+	if _, err := c.writer.Write(size[:n]); err != nil {
+		return err
+	}
+	_, err := c.writer.Write(data)
+	return err
+}
+
+func (c *protoCodec) readFrame() ([]byte, error) {
+	size, err := binary.ReadUvarint(c.reader)
+	if err != nil {
+		return nil, err
+	}
+	if size > protoMaxFrame {
+		return nil, errors.New("proto codec: frame too large")
+	}
+	data := make([]byte, size)
+	_, err = io.ReadFull(c.reader, data)
+	return data, err
+}
+
+func (c *protoCodec) readHeader(h *protoHeader) error {
+	data, err := c.readFrame()
+	if err != nil {
+		return err
+	}
+
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			h.ServiceMethod, n = protowire.ConsumeString(data)
+		case num == 2 && typ == protowire.VarintType:
+			h.Seq, n = protowire.ConsumeVarint(data)
+		case num == 3 && typ == protowire.BytesType:
+			h.Error, n = protowire.ConsumeString(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func (c *protoCodec) readBody(body any) error {
+	data, err := c.readFrame()
+	if err != nil || body == nil {
+		return err
+	}
+
+	return unmarshalProtoBody(data, body)
+}
+
+func marshalProtoBody(body any) ([]byte, error) {
+	var value uint64
+	switch v := body.(type) {
+	case nil:
+		return nil, nil
+	case proto.Message:
+		return proto.Marshal(v)
+	case int:
+		value = uint64(v)
+	case *int:
+		value = uint64(*v)
+	case bool:
+		value = protowire.EncodeBool(v)
+	case *bool:
+		value = protowire.EncodeBool(*v)
+	case []byte:
+		return appendProtoBytes(v), nil
+	case *[]byte:
+		return appendProtoBytes(*v), nil
+	default:
+		return nil, fmt.Errorf("proto codec: %T is not a proto.Message", body)
+	}
+
+	if value == 0 {
+		return nil, nil
+	}
+	data := protowire.AppendTag(nil, 1, protowire.VarintType)
+	return protowire.AppendVarint(data, value), nil
+}
+
+func unmarshalProtoBody(data []byte, body any) error {
+	switch v := body.(type) {
+	case proto.Message:
+		return proto.Unmarshal(data, v)
+	case *int, *bool, *[]byte:
+	default:
+		return fmt.Errorf("proto codec: %T is not a proto.Message", body)
+	}
+
+	var value uint64
+	var bytes []byte
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if num == 1 && typ == protowire.VarintType {
+			value, n = protowire.ConsumeVarint(data)
+		} else if num == 1 && typ == protowire.BytesType {
+			bytes, n = protowire.ConsumeBytes(data)
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+
+	switch v := body.(type) {
+	case *int:
+		*v = int(value)
+	case *bool:
+		*v = protowire.DecodeBool(value)
+	case *[]byte:
+		*v = append([]byte{}, bytes...)
+	}
+	return nil
+}
+
+func appendProtoBytes(v []byte) []byte {
+	if len(v) == 0 {
+		return nil
+	}
+	data := protowire.AppendTag(nil, 1, protowire.BytesType)
+	return protowire.AppendBytes(data, v)
+}
@@ -0,0 +1,86 @@
+package spr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/rpc"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// bufConn 以内存缓冲模拟连接
+type bufConn struct {
+	bytes.Buffer
+}
+
+func (c *bufConn) Close() error { return nil }
+
+func TestProtoCodecRoundTrip(t *testing.T) {
+	conn := &bufConn{}
+	codec := newProtoCodec(conn)
+	if err := codec.WriteRequest(&rpc.Request{ServiceMethod: "svr.Square", Seq: 7}, wrapperspb.Int64(5)); err != nil {
+		t.Fatal("write:", err)
+	}
+	if err := codec.WriteResponse(&rpc.Response{ServiceMethod: "svr.Square", Seq: 7, Error: "failed"},
+		wrapperspb.Int64(25)); err != nil {
+		t.Fatal("write:", err)
+	}
+
+	var req rpc.Request
+	args := &wrapperspb.Int64Value{}
+	if err := codec.ReadRequestHeader(&req); err != nil || req.ServiceMethod != "svr.Square" || req.Seq != 7 {
+		t.Fatal("request header:", err, req)
+	}
+	if err := codec.ReadRequestBody(args); err != nil || args.Value != 5 {
+		t.Fatal("request body:", err, args)
+	}
+
+	// 出错的响应不带消息体
+	var resp rpc.Response
+	reply := &wrapperspb.Int64Value{}
+	if err := codec.ReadResponseHeader(&resp); err != nil || resp.Error != "failed" || resp.Seq != 7 {
+		t.Fatal("response header:", err, resp)
+	}
+	if err := codec.ReadResponseBody(reply); err != nil || reply.Value != 0 {
+		t.Fatal("response body:", err, reply)
+	}
+
+	if err := codec.WriteRequest(&rpc.Request{ServiceMethod: "svr.Multiply"}, &TestArgs{1, 2}); err == nil ||
+		!strings.Contains(err.Error(), "not a proto.Message") {
+		t.Fatal("expect error for non proto.Message:", err)
+	}
+	if conn.Len() != 0 {
+		t.Fatal("partial frame written:", conn.Len())
+	}
+}
+
+func TestProtoCodecBadFrame(t *testing.T) {
+	var size [binary.MaxVarintLen64]byte
+
+	// 超过上限的长度前缀
+	conn := &bufConn{}
+	conn.Write(size[:binary.PutUvarint(size[:], protoMaxFrame+1)])
+	var req rpc.Request
+	if err := newProtoCodec(conn).ReadRequestHeader(&req); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatal("expect frame too large:", err)
+	}
+
+	// 数据不完整的帧
+	conn = &bufConn{}
+	conn.Write(size[:binary.PutUvarint(size[:], 10)])
+	conn.WriteString("abc")
+	if err := newProtoCodec(conn).ReadRequestHeader(&req); err != io.ErrUnexpectedEOF {
+		t.Fatal("expect unexpected EOF:", err)
+	}
+
+	// 无效的头部
+	conn = &bufConn{}
+	conn.Write(size[:binary.PutUvarint(size[:], 2)])
+	conn.Write([]byte{0x0a, 0x05})
+	if err := newProtoCodec(conn).ReadRequestHeader(&req); err == nil {
+		t.Fatal("expect parse error")
+	}
+}
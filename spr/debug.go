@@ -0,0 +1,98 @@
+package spr
+
+import (
+	"github.com/livekit/protocol/logger"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DebugAttach 调试附加参数
+//
+//	(父进程不启动该子进程, 而是输出完整的启动命令, 等待开发者手动(如在调试器中)启动后再连接)
+type DebugAttach struct {
+	RpcAddr string        // 子进程RPC服务地址(端口号或"unix:"+套接字路径), 空表示自动分配
+	Timeout time.Duration // 等待手动启动的时间(<=0表示10分钟)
+}
+
+// 环境变量格式: SPR_DEBUG_ATTACH=<childName>[@<rpcAddr>][,<childName>[@<rpcAddr>]...]
+//
+//	如: SPR_DEBUG_ATTACH=worker@46000
+const envDebugAttach = "SPR_DEBUG_ATTACH"
+
+const defaultDebugTimeout = 10 * time.Minute
+
+// SetDebugAttach 设置调试附加(nil表示正常启动, 此时仍可通过环境变量SPR_DEBUG_ATTACH按子进程名开启)
+//
+//	(需在创建子进程前设置; 调试附加的子进程不进行心跳检测和自动重启)
+func (p *SubProcCaller) SetDebugAttach(options *DebugAttach) *SubProcCaller {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.debug = options
+	return p
+}
+
+//////////////////////////////////// private functions
+
+// debugAttachOf 子进程的调试附加参数, 未开启时返回nil
+func debugAttachOf(name string, options *DebugAttach) *DebugAttach {
+	if options != nil {
+		return options
+	}
+
+	value := os.Getenv(envDebugAttach)
+	if value == "" {
+		return nil
+	}
+	for _, item := range strings.Split(value, ",") {
+		childName, addr, _ := strings.Cut(strings.TrimSpace(item), "@")
+		if childName != name {
+			continue
+		}
+		if addr != "" {
+			if err := checkAddress(addr); err != nil {
+				logger.Warnw("[parent]invalid "+envDebugAttach+": "+item, err)
+				addr = ""
+			}
+		}
+		return &DebugAttach{RpcAddr: addr}
+	}
+	return nil
+}
+
+func (d *DebugAttach) timeout() time.Duration {
+	if d.Timeout <= 0 {
+		return defaultDebugTimeout
+	}
+	return d.Timeout
+}
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellQuote 按shell语法转义单个参数
+func shellQuote(s string) string {
+	if s != "" && shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// commandLine 可直接在shell中执行的完整启动命令
+func commandLine(dir string, env []string, program string, args []string) string {
+	var parts []string
+	if dir != "" {
+		parts = append(parts, "cd", shellQuote(dir), "&&")
+	}
+	if len(env) > 0 {
+		parts = append(parts, "env")
+		for _, kv := range env {
+			parts = append(parts, shellQuote(kv))
+		}
+	}
+	parts = append(parts, shellQuote(program))
+	for _, arg := range args {
+		parts = append(parts, shellQuote(arg))
+	}
+	return strings.Join(parts, " ")
+}
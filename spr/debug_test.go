@@ -0,0 +1,62 @@
+package spr
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDebugAttachOf(t *testing.T) {
+	cases := []struct {
+		env  string
+		name string
+		want *DebugAttach
+	}{
+		{"", "worker", nil},
+		{"worker", "worker", &DebugAttach{}},
+		{"worker@46000", "worker", &DebugAttach{RpcAddr: "46000"}},
+		{"worker@unix:/tmp/spr/rpc.sock", "worker", &DebugAttach{RpcAddr: "unix:/tmp/spr/rpc.sock"}},
+		{"other@46000, worker@46001", "worker", &DebugAttach{RpcAddr: "46001"}},
+		{"other@46000", "worker", nil},
+		{"worker-1", "worker", nil},
+		{"worker@bad", "worker", &DebugAttach{}}, // 非法地址时自动分配
+		{"worker@unix:", "worker", &DebugAttach{}},
+	}
+	for _, c := range cases {
+		t.Setenv(envDebugAttach, c.env)
+		if got := debugAttachOf(c.name, nil); !reflect.DeepEqual(got, c.want) {
+			t.Fatal(c.env, "got:", got, "want:", c.want)
+		}
+	}
+
+	// SetDebugAttach优先于环境变量
+	t.Setenv(envDebugAttach, "worker@46000")
+	options := &DebugAttach{RpcAddr: "47000", Timeout: time.Second}
+	if got := debugAttachOf("worker", options); got != options || got.timeout() != time.Second {
+		t.Fatal("options:", got)
+	}
+	if got := debugAttachOf("worker", nil); got.timeout() != defaultDebugTimeout {
+		t.Fatal("default timeout:", got.timeout())
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	cases := []struct {
+		dir     string
+		env     []string
+		program string
+		args    []string
+		want    string
+	}{
+		{"", nil, "/bin/runner", nil, "/bin/runner"},
+		{"", nil, "/bin/runner", []string{"worker", "info", "46000", ""}, "/bin/runner worker info 46000 ''"},
+		{"/work dir", nil, "./runner", []string{"a b"}, "cd '/work dir' && ./runner 'a b'"},
+		{"", []string{envToken + "=abc", "X=it's"}, "/bin/runner", []string{"$HOME"},
+			"env " + envToken + "=abc 'X=it'\\''s' /bin/runner '$HOME'"},
+	}
+	for _, c := range cases {
+		if got := commandLine(c.dir, c.env, c.program, c.args); got != c.want {
+			t.Fatal("got:", got, "want:", c.want)
+		}
+	}
+}
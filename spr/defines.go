@@ -3,6 +3,8 @@ package spr
 import (
 	"encoding/gob"
 	"net"
+	"net/rpc"
+	"os"
 	"strconv"
 	"sync"
 )
@@ -11,10 +13,19 @@ const (
 	BaseChildName = "baseChild"
 	StopMethod    = BaseChildName + ".RpcStopChild"
 	PingMethod    = BaseChildName + ".RpcPing"
+	// RuntimeStatsMethod 查询子进程Go运行时状态, reply为JSON编码的RuntimeStats
+	RuntimeStatsMethod = BaseChildName + ".RpcRuntimeStats"
 
-	debugEnabled      = false
-	debugRpcPort      = "46000"
-	debugCallbackPort = "46001"
+	// 子进程在该时间(毫秒)内未收到心跳即判定父进程丢失
+	envParentTimeout = "SPR_PARENT_TIMEOUT"
+	// 子进程RPC服务的握手密钥
+	envToken = "SPR_TOKEN"
+	// 回调监听器的握手密钥
+	envCallbackToken = "SPR_CB_TOKEN"
+	// 子进程RPC服务的编解码方式
+	envCodec = "SPR_CODEC"
+	// 回调监听器的编解码方式
+	envCallbackCodec = "SPR_CB_CODEC"
 )
 
 type RunnerTyper interface {
@@ -30,6 +41,26 @@ func LoadRpcTypes(typer RunnerTyper) {
 	}
 }
 
+// CallerBinder 回调服务的可选接口
+//
+//	(实现该接口时, 每个子进程的回调连接使用BindCaller返回的服务实例, 从而获知调用方子进程)
+type CallerBinder interface {
+	BindCaller(childName string) RpcSvr
+}
+
+// registerServices 将服务注册到server, caller非空时按CallerBinder绑定调用方
+func registerServices(server *rpc.Server, rpcObjs map[string] /*rpcObjName*/ RpcSvr, caller string) error {
+	for rpcName, rpcSvr := range rpcObjs {
+		if binder, ok := rpcSvr.(CallerBinder); ok && caller != "" {
+			rpcSvr = binder.BindCaller(caller)
+		}
+		if err := server.RegisterName(rpcName, rpcSvr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 var gMutex = sync.Mutex{}
 var gMinPort = 49152
 var gMaxPort = 65535
@@ -85,3 +116,10 @@ func max(a, b int) int {
 	}
 	return b
 }
+
+// takeEnv 读取并清除环境变量(避免泄露给孙进程)
+func takeEnv(key string) string {
+	value := os.Getenv(key)
+	os.Unsetenv(key)
+	return value
+}
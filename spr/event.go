@@ -0,0 +1,274 @@
+package spr
+
+import (
+	"encoding/json"
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	"sync"
+	"time"
+)
+
+// Event 子进程发布的事件
+type Event struct {
+	Child   string          `json:"child"`             // 发布事件的子进程名
+	Topic   string          `json:"topic"`             // 主题
+	Seq     uint64          `json:"seq"`               // 同一子进程同一主题内从1递增的序号
+	Payload json.RawMessage `json:"payload,omitempty"` // JSON编码的事件内容
+}
+
+// Decode 将事件内容解码到v
+func (e *Event) Decode(v any) error {
+	return json.Unmarshal(e.Payload, v)
+}
+
+// EventHandler 父进程处理订阅的事件
+//
+//	(在独立的协程中回调, 不占用回调连接; 同一子进程的事件按发布顺序依次回调)
+type EventHandler func(event *Event)
+
+const (
+	eventServiceName = "sprEvents"
+	eventMethod      = eventServiceName + ".RpcPublish"
+	maxPendingEvents = 10000 // 子进程最多缓存的未发送事件数
+	maxEventBatch    = 100   // 单次发送的最大事件数
+	maxDispatchQueue = 64    // 父进程每个子进程待回调的最大批次数, 超过时阻塞子进程的发送
+)
+
+// Publish 发布事件, payload按JSON编码
+//
+//	(不等待父进程处理; 回调连接建立前的事件被缓存, 连接后按发布顺序发送;
+//	 回调连接失败或发送失败后未发送的事件被丢弃, 之后的发布返回REMOTE_SYSTEM_ERROR)
+func (c *SubProcRunner) Publish(topic string, payload any) base.Result {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return base.INVALID_PARAM.AppendErr("encode event payload failed", err)
+	}
+
+	c.mutex.Lock()
+	ctx := c.ctx
+	if ctx != nil && ctx.CallbackAddr == "" {
+		c.mutex.Unlock()
+		res := base.LOGICAL_ERROR.AppendMsg("parent not set callback port")
+		logger.Errorw("[child]Publish failed", res)
+		return res
+	}
+	c.mutex.Unlock()
+
+	return c.getEvents().push(topic, data)
+}
+
+// Subscribe 订阅子进程发布的事件, childName为空表示订阅所有子进程
+func (l *SubProcCBListener) Subscribe(childName, topic string, handler EventHandler) *SubProcCBListener {
+	l.subMutex.Lock()
+	defer l.subMutex.Unlock()
+	if l.subscribers == nil {
+		l.subscribers = map[eventKey][]EventHandler{}
+	}
+	key := eventKey{childName, topic}
+	l.subscribers[key] = append(l.subscribers[key], handler)
+	return l
+}
+
+// Unsubscribe 取消订阅
+func (l *SubProcCBListener) Unsubscribe(childName, topic string) *SubProcCBListener {
+	l.subMutex.Lock()
+	defer l.subMutex.Unlock()
+	delete(l.subscribers, eventKey{childName, topic})
+	return l
+}
+
+//////////////////////////////////// private functions
+
+type eventKey struct {
+	child string
+	topic string
+}
+
+// eventBatch 子进程单次发送的事件
+type eventBatch struct {
+	Events []*Event `json:"events"`
+}
+
+// eventQueue 子进程待发送的事件
+type eventQueue struct {
+	mutex   sync.Mutex
+	events  []*Event
+	sending int // 已取出但尚未发送完成的事件数
+	seqs    map[string]uint64
+	notify  chan struct{}
+	dead    base.Result // 无法再发送(回调连接失败或断开)时的原因
+}
+
+func newEventQueue() *eventQueue {
+	return &eventQueue{seqs: map[string]uint64{}, notify: make(chan struct{}, 1)}
+}
+
+func (q *eventQueue) push(topic string, payload []byte) base.Result {
+	q.mutex.Lock()
+	if q.dead != nil {
+		q.mutex.Unlock()
+		res := q.dead
+		logger.Warnw("[child]Publish failed", res, "topic", topic)
+		return res
+	}
+	if len(q.events) >= maxPendingEvents {
+		q.mutex.Unlock()
+		res := base.TRY_AGAIN_LATER.AppendMsg("too many pending events")
+		logger.Warnw("[child]Publish failed", res, "topic", topic)
+		return res
+	}
+	q.seqs[topic]++
+	q.events = append(q.events, &Event{Topic: topic, Seq: q.seqs[topic], Payload: payload})
+	q.mutex.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return base.SUCCESS
+}
+
+func (q *eventQueue) take(max int) []*Event {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	n := len(q.events)
+	if n > max {
+		n = max
+	}
+	events := q.events[:n:n]
+	q.events = q.events[n:]
+	q.sending += n
+	return events
+}
+
+// sent 取出的事件已发送完成(或发送失败被丢弃)
+func (q *eventQueue) sent(n int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.sending -= n
+}
+
+// kill 标记队列无法再发送并丢弃未发送的事件, 返回丢弃的事件数
+func (q *eventQueue) kill(reason string) int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.dead == nil {
+		q.dead = base.REMOTE_SYSTEM_ERROR.AppendMsg(reason)
+	}
+	dropped := len(q.events)
+	q.events = nil
+	return dropped
+}
+
+// pending 未发送完成的事件数(含发送中的)
+func (q *eventQueue) pending() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.events) + q.sending
+}
+
+// getEvents 返回事件队列, 不存在时创建
+func (c *SubProcRunner) getEvents() *eventQueue {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.events == nil {
+		c.events = newEventQueue()
+	}
+	return c.events
+}
+
+// sendEvents 回调连接建立后按顺序发送事件, 直到子进程退出或发送失败
+func (c *SubProcRunner) sendEvents(callback *SubProcCallback, done chan struct{}) {
+	events := c.getEvents()
+
+	for {
+		batch := events.take(maxEventBatch)
+		if len(batch) == 0 {
+			select {
+			case <-done:
+				events.kill("runner exited")
+				return
+			case <-events.notify:
+				continue
+			}
+		}
+
+		data, _ := json.Marshal(&eventBatch{Events: batch})
+		var count int
+		err := callback.Call(eventMethod, data, &count)
+		events.sent(len(batch))
+		if err != nil {
+			// 回调连接已不可用, 之后的发布直接失败, flushEvents也不必等待
+			dropped := events.kill("send events failed: " + err.Error())
+			logger.Warnw("[child]send events failed", err, "dropped", len(batch)+dropped)
+			return
+		}
+	}
+}
+
+// flushEvents 等待已发布的事件发送完成
+func (c *SubProcRunner) flushEvents(until time.Time) {
+	c.mutex.Lock()
+	events := c.events
+	c.mutex.Unlock()
+	if events == nil {
+		return
+	}
+
+	for events.pending() > 0 && time.Now().Before(until) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// eventService 父进程接收事件的内置回调服务(每个子进程连接一个实例)
+//
+//	(事件在dispatchLoop协程中回调, 同一子进程的事件保持发布顺序)
+type eventService struct {
+	listener *SubProcCBListener
+	child    string // 握手时校验过的子进程名
+	batches  chan []*Event
+}
+
+func newEventService(listener *SubProcCBListener, child string) *eventService {
+	s := &eventService{listener: listener, child: child, batches: make(chan []*Event, maxDispatchQueue)}
+	go s.dispatchLoop()
+	return s
+}
+
+// close 回调连接关闭(且没有进行中的RpcPublish)后调用, 已接收的事件回调完后退出dispatchLoop
+func (s *eventService) close() {
+	close(s.batches)
+}
+
+func (s *eventService) dispatchLoop() {
+	for batch := range s.batches {
+		for _, event := range batch {
+			s.listener.dispatch(event)
+		}
+	}
+}
+
+func (s *eventService) RpcPublish(args []byte, reply *int) error {
+	var batch eventBatch
+	if err := json.Unmarshal(args, &batch); err != nil {
+		return err
+	}
+
+	for _, event := range batch.Events {
+		event.Child = s.child
+	}
+	s.batches <- batch.Events
+	*reply = len(batch.Events)
+	return nil
+}
+
+func (l *SubProcCBListener) dispatch(event *Event) {
+	l.subMutex.Lock()
+	handlers := append([]EventHandler{}, l.subscribers[eventKey{event.Child, event.Topic}]...)
+	handlers = append(handlers, l.subscribers[eventKey{"", event.Topic}]...)
+	l.subMutex.Unlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
@@ -0,0 +1,267 @@
+package spr
+
+import (
+	"github.com/patstar123/go-base"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventRecorder 按到达顺序记录收到的事件
+type eventRecorder struct {
+	mutex  sync.Mutex
+	events []*Event
+	delay  func(event *Event) time.Duration
+}
+
+func (r *eventRecorder) handle(event *Event) {
+	if r.delay != nil {
+		time.Sleep(r.delay(event))
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *eventRecorder) wait(t *testing.T, count int) []*Event {
+	t.Helper()
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		r.mutex.Lock()
+		n := len(r.events)
+		r.mutex.Unlock()
+		if n >= count {
+			break
+		}
+	}
+	return r.snapshot()
+}
+
+func (r *eventRecorder) snapshot() []*Event {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]*Event{}, r.events...)
+}
+
+// checkSeqs 事件均来自child且序号从1连续递增
+func checkSeqs(t *testing.T, events []*Event, child string, count int) {
+	t.Helper()
+	if len(events) != count {
+		t.Fatal("events:", len(events), "want", count)
+	}
+	for i, event := range events {
+		var value int
+		if event.Child != child || event.Seq != uint64(i+1) || event.Decode(&value) != nil || value != i {
+			t.Fatal("event:", i, event.Child, event.Seq, value)
+		}
+	}
+}
+
+func startEventListener(t *testing.T) *SubProcCBListener {
+	listener := &SubProcCBListener{}
+	if res := listener.StartLoop(t.Name(), nil); !res.IsOk() {
+		t.Fatal("listener:", res)
+	}
+	t.Cleanup(listener.StopLoop)
+	return listener
+}
+
+func startEventFake(t *testing.T, listener *SubProcCBListener, name string) *FakeRunner {
+	fake := NewFakeRunner(name).SetCallbackListener(listener)
+	if res := fake.Start(nil, nil); !res.IsOk() {
+		t.Fatal("start:", res)
+	}
+	t.Cleanup(func() { fake.Stop() })
+	return fake
+}
+
+func TestPublishBeforeConnect(t *testing.T) {
+	listener := startEventListener(t)
+	recorder := &eventRecorder{}
+	listener.Subscribe("fake", "count", recorder.handle)
+
+	// 回调连接建立前发布的事件被缓存
+	fake := NewFakeRunner("fake").SetCallbackListener(listener)
+	for i := 0; i < 5; i++ {
+		if res := fake.Runner().Publish("count", i); !res.IsOk() {
+			t.Fatal("publish:", res)
+		}
+	}
+	if res := fake.Start(nil, nil); !res.IsOk() {
+		t.Fatal("start:", res)
+	}
+	defer fake.Stop()
+	for i := 5; i < 250; i++ {
+		fake.Runner().Publish("count", i)
+	}
+
+	checkSeqs(t, recorder.wait(t, 250), "fake", 250)
+}
+
+func TestPublishFilter(t *testing.T) {
+	listener := startEventListener(t)
+	fakeA := startEventFake(t, listener, "a")
+	fakeB := startEventFake(t, listener, "b")
+
+	onlyA, allB, allC := &eventRecorder{}, &eventRecorder{}, &eventRecorder{}
+	listener.Subscribe("a", "t1", onlyA.handle)
+	listener.Subscribe("", "t2", allB.handle)
+	listener.Subscribe("", "t3", allC.handle)
+	listener.Unsubscribe("", "t3")
+
+	for i := 0; i < 100; i++ {
+		fakeA.Runner().Publish("t1", i)
+		fakeB.Runner().Publish("t1", i)
+		fakeA.Runner().Publish("t2", i)
+		fakeB.Runner().Publish("t2", i)
+		fakeA.Runner().Publish("t3", i)
+	}
+
+	// 同一子进程的事件按发布顺序到达, 不同子进程之间不保证顺序
+	checkSeqs(t, onlyA.wait(t, 100), "a", 100)
+	events := allB.wait(t, 200)
+	byChild := map[string][]*Event{}
+	for _, event := range events {
+		if event.Topic != "t2" {
+			t.Fatal("topic:", event.Topic)
+		}
+		byChild[event.Child] = append(byChild[event.Child], event)
+	}
+	checkSeqs(t, byChild["a"], "a", 100)
+	checkSeqs(t, byChild["b"], "b", 100)
+
+	time.Sleep(50 * time.Millisecond)
+	if n := len(onlyA.snapshot()); n != 100 {
+		t.Fatal("filter by child:", n)
+	}
+	if n := len(allC.snapshot()); n != 0 {
+		t.Fatal("unsubscribed:", n)
+	}
+}
+
+func TestPublishFlushOnExit(t *testing.T) {
+	listener := startEventListener(t)
+	const count = 250
+	recorder := &eventRecorder{delay: func(event *Event) time.Duration {
+		// 最后一批处理较慢, 退出时须等待发送中的事件
+		if event.Seq == count {
+			return 200 * time.Millisecond
+		}
+		return 0
+	}}
+	listener.Subscribe("fake", "count", recorder.handle)
+
+	fake := NewFakeRunner("fake").SetCallbackListener(listener)
+	if res := fake.Start(map[string]RpcSvr{"svr": &TestSvr{}}, nil); !res.IsOk() {
+		t.Fatal("start:", res)
+	}
+	defer fake.Stop()
+	caller := &SubProcCaller{}
+	if res := fake.Attach(caller, []RunnerTyper{&TestSvr{}}); !res.IsOk() {
+		t.Fatal("attach:", res)
+	}
+
+	for i := 0; i < count; i++ {
+		fake.Runner().Publish("count", i)
+	}
+	caller.TerminateRunnerSafely(3 * time.Second)
+	if res := fake.Wait(); !res.IsOk() {
+		t.Fatal("exit:", res)
+	}
+	checkSeqs(t, recorder.wait(t, count), "fake", count)
+}
+
+func TestCallbackTokenPerChild(t *testing.T) {
+	listener := startEventListener(t)
+	fakeA := startEventFake(t, listener, "a")
+	startEventFake(t, listener, "b")
+
+	// 使用a的密钥冒充b
+	callback := (&SubProcCallback{}).SetAuth("b", fakeA.ctx.cbToken)
+	if res := callback.ConnectListener(listener.GetAddress(), nil); res.IsOk() {
+		callback.Disconnect()
+		t.Fatal("impersonation accepted")
+	}
+	callback = (&SubProcCallback{}).SetAuth("c", fakeA.ctx.cbToken)
+	if res := callback.ConnectListener(listener.GetAddress(), nil); res.IsOk() {
+		callback.Disconnect()
+		t.Fatal("unknown child accepted")
+	}
+	if n := listener.AuthFailures(); n != 2 {
+		t.Fatal("failures:", n)
+	}
+
+	callback = (&SubProcCallback{}).SetAuth("a", fakeA.ctx.cbToken)
+	if res := callback.ConnectListener(listener.GetAddress(), nil); !res.IsOk() {
+		t.Fatal("connect:", res)
+	}
+	callback.Disconnect()
+}
+
+func TestPublishSlowHandler(t *testing.T) {
+	listener := startEventListener(t)
+	block := make(chan struct{})
+	defer close(block)
+	listener.Subscribe("fake", "count", func(event *Event) { <-block })
+	fake := startEventFake(t, listener, "fake")
+
+	// 处理阻塞不影响子进程的发送
+	for i := 0; i < maxEventBatch*3; i++ {
+		fake.Runner().Publish("count", i)
+	}
+	start := time.Now()
+	fake.Runner().flushEvents(start.Add(3 * time.Second))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatal("flush blocked by handler:", elapsed)
+	}
+}
+
+func TestPublishAfterCallbackLost(t *testing.T) {
+	// 回调连接失败后发布直接失败, 退出时不等待
+	listener := &SubProcCBListener{}
+	if res := listener.StartLoop(t.Name(), nil); !res.IsOk() {
+		t.Fatal("listener:", res)
+	}
+	fake := NewFakeRunner("fake").SetCallbackListener(listener)
+	listener.StopLoop()
+	if res := fake.Start(nil, nil); !res.IsOk() {
+		t.Fatal("start:", res)
+	}
+	defer fake.Stop()
+
+	var res base.Result
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if res = fake.Runner().Publish("count", 0); !res.IsOk() {
+			break
+		}
+	}
+	if !res.IsEqual(base.REMOTE_SYSTEM_ERROR) {
+		t.Fatal("publish:", res)
+	}
+	start := time.Now()
+	fake.Runner().flushEvents(start.Add(3 * time.Second))
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatal("flush waited:", elapsed)
+	}
+}
+
+func TestCallbackTokenRevoked(t *testing.T) {
+	listener := startEventListener(t)
+
+	// 模拟工作池重启产生的不同子进程名, 退出后密钥都被收回
+	for i := 0; i < 3; i++ {
+		fake := NewFakeRunner("child-" + strconv.Itoa(i)).SetCallbackListener(listener)
+		if res := fake.Start(nil, nil); !res.IsOk() {
+			t.Fatal("start:", res)
+		}
+		if listener.tokenOf(fake.name) == "" {
+			t.Fatal("token not issued:", i)
+		}
+		fake.Stop()
+	}
+	listener.authMutex.Lock()
+	defer listener.authMutex.Unlock()
+	if n := len(listener.tokens); n != 0 {
+		t.Fatal("tokens left:", n)
+	}
+}
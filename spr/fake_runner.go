@@ -0,0 +1,286 @@
+package spr
+
+import (
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	"github.com/patstar123/go-base/utils"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// FakeRunner 在当前进程内模拟子进程, 用于单元测试RPC服务
+//
+//	. 与SubProcRunner使用相同的服务注册(含BaseChild)、CustomTypeValues类型注册、握手和编解码
+//	. 在回环地址上提供服务, 通过Attach将SubProcCaller连接到该服务后即可测试Call等调用
+//	. 支持故障注入: 延迟调用(SetDelay)、断开连接(DropConnections)、模拟崩溃(Crash)
+type FakeRunner struct {
+	mutex     sync.Mutex
+	name      string
+	transport Transport
+	codec     Codec
+	cbAddr    string
+	runner    *SubProcRunner
+
+	ctx     *RunnerContext
+	conns   map[net.Conn]struct{}
+	delay   time.Duration
+	done    chan struct{} // 服务退出时关闭
+	exitRes base.Result
+}
+
+func NewFakeRunner(name string) *FakeRunner {
+	return &FakeRunner{name: name, runner: &SubProcRunner{}, conns: map[net.Conn]struct{}{}}
+}
+
+// SetTransport 设置RPC服务的传输方式(默认TransportTCP)
+func (f *FakeRunner) SetTransport(transport Transport) *FakeRunner {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.transport = transport
+	return f
+}
+
+// SetCodec 设置RPC服务的编解码方式(默认CodecGob, 须与Attach的SubProcCaller一致)
+func (f *FakeRunner) SetCodec(codec Codec) *FakeRunner {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.codec = codec
+	return f
+}
+
+// SetCallbackListener 设置回调监听器, 模拟的子进程将连接到该监听器(用于测试Callback/Publish)
+func (f *FakeRunner) SetCallbackListener(listener *SubProcCBListener) *FakeRunner {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.cbAddr = ""
+	if listener != nil {
+		f.cbAddr = listener.GetAddress()
+	}
+	return f
+}
+
+// Runner 模拟的子进程执行器(可在Start之前设置SetShutdownHook/SetStreamHandler, 或调用Publish/Callback)
+func (f *FakeRunner) Runner() *SubProcRunner {
+	return f.runner
+}
+
+// Start 在当前进程内启动RPC服务
+//
+//	(不阻塞, 服务开始监听后返回)
+func (f *FakeRunner) Start(rpcObjs map[string] /*rpcObjName*/ RpcSvr, cbTypes []CallbackTyper) base.Result {
+	f.mutex.Lock()
+	if f.done != nil {
+		f.mutex.Unlock()
+		return base.LOGICAL_ERROR.AppendMsg("has been started")
+	}
+	rpcAddr, cleanup, res := newAddress(f.transport, "rpc")
+	if !res.IsOk() {
+		f.mutex.Unlock()
+		return res
+	}
+	ctx := &RunnerContext{
+		Name:          f.name,
+		LogLevel:      logger.GetLogLevel(),
+		RpcAddr:       rpcAddr,
+		CallbackAddr:  f.cbAddr,
+		Codec:         f.codec,
+		CallbackCodec: CodecGob,
+		token:         utils.RandomSecret(),
+	}
+	if f.cbAddr != "" {
+		ctx.cbToken, ctx.CallbackCodec = issueListenerToken(f.cbAddr, f.name)
+		release, cbAddr := cleanup, f.cbAddr
+		cleanup = func() {
+			revokeListenerToken(cbAddr, ctx.Name, ctx.cbToken)
+			release()
+		}
+	}
+	done := make(chan struct{})
+	f.ctx, f.done = ctx, done
+	f.mutex.Unlock()
+
+	f.runner.mutex.Lock()
+	f.runner.wrapCodec = f.wrap
+	f.runner.mutex.Unlock()
+
+	go func() {
+		res := f.runner.serve(ctx, rpcObjs, cbTypes)
+		f.mutex.Lock()
+		f.exitRes = res
+		f.mutex.Unlock()
+		cleanup()
+		close(done)
+	}()
+
+	// 等待开始监听
+	for {
+		select {
+		case <-done:
+			f.mutex.Lock()
+			defer f.mutex.Unlock()
+			return f.exitRes
+		default:
+		}
+		f.runner.mutex.Lock()
+		listening := f.runner.listener != nil
+		f.runner.mutex.Unlock()
+		if listening {
+			return base.SUCCESS
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Attach 将caller连接到模拟的子进程(替代CreateAndConnectRunner)
+//
+//	(caller的编解码方式与模拟的子进程不一致时握手失败; 之后可通过caller.TerminateRunnerSafely优雅退出)
+func (f *FakeRunner) Attach(caller *SubProcCaller, typers []RunnerTyper) base.Result {
+	f.mutex.Lock()
+	ctx := f.ctx
+	f.mutex.Unlock()
+	if ctx == nil {
+		return base.LOGICAL_ERROR.AppendMsg("start fake runner first: " + f.name)
+	}
+
+	return caller.attach(ctx.Name, ctx.RpcAddr, ctx.token, typers)
+}
+
+// SetDelay 设置每个调用在执行前的延迟(0表示不延迟)
+//
+//	(同一连接上的请求依次读取, 延迟会累加)
+func (f *FakeRunner) SetDelay(delay time.Duration) *FakeRunner {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.delay = delay
+	return f
+}
+
+// DropConnections 断开当前所有RPC连接(服务仍在监听), 返回断开的连接数
+func (f *FakeRunner) DropConnections() int {
+	f.mutex.Lock()
+	conns := f.conns
+	f.conns = map[net.Conn]struct{}{}
+	f.mutex.Unlock()
+
+	for conn := range conns {
+		conn.Close()
+	}
+	return len(conns)
+}
+
+// Crash 模拟子进程崩溃: 立即停止监听并断开所有连接, 进行中的调用不会得到应答
+func (f *FakeRunner) Crash() {
+	logger.Infow("[child]fake runner crashed: " + f.name)
+	f.runner.StopLoop()
+	f.DropConnections()
+	f.Wait()
+}
+
+// Stop 停止服务并等待退出
+func (f *FakeRunner) Stop() base.Result {
+	f.runner.StopLoop()
+	return f.Wait()
+}
+
+// Wait 等待服务退出(如被Attach的caller优雅终止), 返回服务的退出结果
+func (f *FakeRunner) Wait() base.Result {
+	f.mutex.Lock()
+	done := f.done
+	f.mutex.Unlock()
+	if done == nil {
+		return base.LOGICAL_ERROR.AppendMsg("not started: " + f.name)
+	}
+
+	<-done
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.exitRes
+}
+
+//////////////////////////////////// private functions
+
+func (f *FakeRunner) wrap(conn net.Conn, codec rpc.ServerCodec) rpc.ServerCodec {
+	f.mutex.Lock()
+	f.conns[conn] = struct{}{}
+	f.mutex.Unlock()
+	return &faultCodec{ServerCodec: codec, runner: f, conn: conn}
+}
+
+// faultCodec 按FakeRunner的设置注入故障
+type faultCodec struct {
+	rpc.ServerCodec
+	runner *FakeRunner
+	conn   net.Conn
+}
+
+func (c *faultCodec) ReadRequestBody(body any) error {
+	err := c.ServerCodec.ReadRequestBody(body)
+	c.runner.mutex.Lock()
+	delay := c.runner.delay
+	c.runner.mutex.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return err
+}
+
+func (c *faultCodec) Close() error {
+	c.runner.mutex.Lock()
+	delete(c.runner.conns, c.conn)
+	c.runner.mutex.Unlock()
+	return c.ServerCodec.Close()
+}
+
+// attach 连接已在运行的子进程RPC服务(不启动进程, 不自动重启)
+func (p *SubProcCaller) attach(name, rpcAddr, token string, typers []RunnerTyper) base.Result {
+	p.mutex.Lock()
+	if p.cli != nil || p.cmd != nil || p.restarting {
+		p.mutex.Unlock()
+		res := base.LOGICAL_ERROR.AppendMsg("has been created")
+		logger.Warnw("[parent]attach failed for "+name, res)
+		return res
+	}
+	codec, minVersion := p.codec, p.minVersion
+	p.mutex.Unlock()
+
+	conn, err := dialAddress(rpcAddr)
+	if err != nil {
+		res := base.INTERNAL_ERROR.AppendErr("connect child process failed", err)
+		logger.Warnw("[parent]attach failed for "+name, res)
+		return res
+	}
+	err = clientHandshake(conn, &handshakeReq{Token: token, Name: name, Codec: codec.String()})
+	if err != nil {
+		conn.Close()
+		res := base.ACTION_ILLEGAL.AppendErr("child process handshake failed", err)
+		logger.Warnw("[parent]attach failed for "+name, res)
+		return res
+	}
+
+	client := newClient(codec, conn)
+	hello, res := sayHello(client, minVersion)
+	if !res.IsOk() {
+		client.Close()
+		logger.Warnw("[parent]attach failed for "+name, res)
+		return res
+	}
+
+	for _, typer := range typers {
+		LoadRpcTypes(typer)
+	}
+
+	p.mutex.Lock()
+	p.name = name
+	p.typers = typers
+	p.cli = client
+	p.hello = hello
+	p.rpcAddr, p.token = rpcAddr, token
+	p.resetQuit()
+	onEvent := p.onEvent
+	p.mutex.Unlock()
+
+	onEvent.On(&ChildEvent{Type: ChildStarted, Name: name})
+	return base.SUCCESS
+}
@@ -0,0 +1,293 @@
+package spr
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/patstar123/go-base"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type TestArgs struct {
+	A, B int
+}
+
+type TestSvr struct{}
+
+func (s *TestSvr) CustomTypeValues() []any {
+	return []any{base.SUCCESS, TestArgs{}}
+}
+
+func (s *TestSvr) Multiply(args *TestArgs, reply *int) error {
+	*reply = args.A * args.B
+	return nil
+}
+
+// Square 参数和返回值为proto.Message, 可用于所有编解码方式
+func (s *TestSvr) Square(args *wrapperspb.Int64Value, reply *wrapperspb.Int64Value) error {
+	reply.Value = args.Value * args.Value
+	return nil
+}
+
+func (s *TestSvr) Check(args *TestArgs, reply *base.Result) error {
+	if args.B == 0 {
+		*reply = base.INVALID_PARAM.AppendMsg("zero").SetData(*args)
+	} else {
+		*reply = base.SUCCESS
+	}
+	return nil
+}
+
+func startFake(t *testing.T, codec Codec) (*FakeRunner, *SubProcCaller) {
+	fake := NewFakeRunner("fake").SetCodec(codec)
+	if res := fake.Start(map[string]RpcSvr{"svr": &TestSvr{}}, nil); !res.IsOk() {
+		t.Fatal("start:", res)
+	}
+	caller := (&SubProcCaller{}).SetCodec(codec)
+	if res := fake.Attach(caller, []RunnerTyper{&TestSvr{}}); !res.IsOk() {
+		t.Fatal("attach:", res)
+	}
+	t.Cleanup(func() {
+		caller.TerminateRunnerFastly()
+		fake.Stop()
+	})
+	return fake, caller
+}
+
+func TestFakeRunnerCall(t *testing.T) {
+	for _, codec := range []Codec{CodecGob, CodecJSON, CodecProto} {
+		_, caller := startFake(t, codec)
+
+		if codec != CodecProto {
+			var value int
+			if err := caller.Call("svr.Multiply", &TestArgs{3, 4}, &value); err != nil || value != 12 {
+				t.Fatal(codec, "Multiply:", err, value)
+			}
+		}
+		square := &wrapperspb.Int64Value{}
+		if err := caller.Call("svr.Square", wrapperspb.Int64(-7), square); err != nil || square.Value != 49 {
+			t.Fatal(codec, "Square:", err, square)
+		}
+		if err, alive := caller.Ping(); err != nil || !alive {
+			t.Fatal(codec, "Ping:", err, alive)
+		}
+		if hello := caller.RunnerHello(); hello == nil || !hello.HasMethod("svr.Square") {
+			t.Fatal(codec, "Hello:", hello)
+		}
+	}
+
+	// proto编解码的参数须为proto.Message
+	_, caller := startFake(t, CodecProto)
+	var value int
+	if err := caller.Call("svr.Multiply", &TestArgs{3, 4}, &value); err == nil {
+		t.Fatal("expect error for non proto.Message args")
+	}
+	square := &wrapperspb.Int64Value{}
+	if err := caller.Call("svr.Square", wrapperspb.Int64(3), square); err != nil || square.Value != 9 {
+		t.Fatal("Square after error:", err, square)
+	}
+
+	// 通过CustomTypeValues注册的类型
+	_, caller = startFake(t, CodecGob)
+	var res base.Result
+	if err := caller.Call("svr.Check", &TestArgs{1, 0}, &res); err != nil {
+		t.Fatal("Check:", err)
+	}
+	if !res.IsEqual(base.INVALID_PARAM) || res.Data().(TestArgs) != (TestArgs{1, 0}) {
+		t.Fatal("Check:", res)
+	}
+}
+
+func TestFakeRunnerCodecMismatch(t *testing.T) {
+	fake := NewFakeRunner("fake").SetCodec(CodecProto)
+	fake.Start(map[string]RpcSvr{"svr": &TestSvr{}}, nil)
+	defer fake.Stop()
+
+	for i, codec := range []Codec{CodecGob, CodecJSON} {
+		res := fake.Attach((&SubProcCaller{}).SetCodec(codec), nil)
+		if !res.IsEqual(base.ACTION_ILLEGAL) {
+			t.Fatal(codec, "attach:", res)
+		}
+		if n := fake.Runner().AuthFailures(); n != int64(i+1) {
+			t.Fatal(codec, "auth failures:", n)
+		}
+	}
+}
+
+func TestFakeRunnerDelay(t *testing.T) {
+	fake, caller := startFake(t, CodecGob)
+	fake.SetDelay(200 * time.Millisecond)
+
+	var value int
+	err := caller.CallTimeout("svr.Multiply", &TestArgs{3, 4}, &value, 50*time.Millisecond)
+	if !isTimeout(err) {
+		t.Fatal("expect timeout:", err)
+	}
+
+	fake.SetDelay(0)
+	time.Sleep(200 * time.Millisecond)
+	if err = caller.CallTimeout("svr.Multiply", &TestArgs{3, 4}, &value, time.Second); err != nil {
+		t.Fatal("Multiply:", err)
+	}
+}
+
+func TestFakeRunnerDropConnections(t *testing.T) {
+	fake, caller := startFake(t, CodecGob)
+	if n := fake.DropConnections(); n != 1 {
+		t.Fatal("dropped:", n)
+	}
+
+	var value int
+	if err := caller.Call("svr.Multiply", &TestArgs{3, 4}, &value); err == nil {
+		t.Fatal("expect error after connection dropped")
+	}
+
+	// 服务仍在监听, 可重新连接
+	caller.TerminateRunnerFastly()
+	if res := fake.Attach(caller, nil); !res.IsOk() {
+		t.Fatal("attach again:", res)
+	}
+	if err := caller.Call("svr.Multiply", &TestArgs{3, 4}, &value); err != nil || value != 12 {
+		t.Fatal("Multiply:", err, value)
+	}
+}
+
+func TestFakeRunnerCrash(t *testing.T) {
+	fake, caller := startFake(t, CodecGob)
+	fake.SetDelay(time.Second)
+
+	// 崩溃时进行中的调用不会得到应答
+	var value int
+	call := make(chan error, 1)
+	go func() { call <- caller.Call("svr.Multiply", &TestArgs{3, 4}, &value) }()
+	time.Sleep(50 * time.Millisecond)
+	fake.Crash()
+
+	select {
+	case err := <-call:
+		if err == nil {
+			t.Fatal("expect error after crash")
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("call not finished after crash")
+	}
+	if err := caller.CallTimeout("svr.Multiply", &TestArgs{3, 4}, &value, time.Second); err == nil {
+		t.Fatal("expect error after crash")
+	}
+}
+
+func TestFakeRunnerTerminate(t *testing.T) {
+	fake, caller := startFake(t, CodecGob)
+	caller.TerminateRunnerSafely(time.Second)
+
+	done := make(chan base.Result, 1)
+	go func() { done <- fake.Wait() }()
+	select {
+	case res := <-done:
+		if !res.IsOk() {
+			t.Fatal("exit:", res)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("fake runner not stopped")
+	}
+}
+
+func TestFakeRunnerHello(t *testing.T) {
+	_, caller := startFake(t, CodecGob)
+	hello := caller.RunnerHello()
+	if hello == nil || hello.Protocol != ProtocolVersion || !hello.HasMethod("svr.Multiply") ||
+		!hello.HasMethod(HelloMethod) {
+		t.Fatal("hello:", hello)
+	}
+
+	fake := NewFakeRunner("fake")
+	fake.Start(map[string]RpcSvr{"svr": &TestSvr{}}, nil)
+	defer fake.Stop()
+	caller = (&SubProcCaller{}).SetMinRunnerVersion(&MinRunnerVersion{Protocol: ProtocolVersion + 1})
+	if res := fake.Attach(caller, nil); !res.IsEqual(base.ACTION_UNSUPPORTED) {
+		t.Fatal("attach:", res)
+	}
+	caller = (&SubProcCaller{}).SetMinRunnerVersion(&MinRunnerVersion{VersionName: "1.2.0"})
+	if res := fake.Attach(caller, nil); !res.IsEqual(base.ACTION_UNSUPPORTED) {
+		t.Fatal("attach:", res)
+	}
+}
+
+func TestIsMethodNotFound(t *testing.T) {
+	_, caller := startFake(t, CodecGob)
+
+	// 服务端实际返回的错误
+	var value int
+	for _, method := range []string{"svr.Unknown", "unknown.Method"} {
+		if err := caller.Call(method, &TestArgs{}, &value); !isMethodNotFound(err, method) {
+			t.Fatal(method, err)
+		}
+	}
+	for _, err := range []error{
+		nil,
+		rpc.ServerError("rpc: can't find method svr.Other"),
+		rpc.ServerError("can't find config file"), // 服务方法自身返回的错误
+		errors.New("rpc: can't find method svr.Unknown"),
+	} {
+		if isMethodNotFound(err, "svr.Unknown") {
+			t.Fatal("matched:", err)
+		}
+	}
+}
+
+func TestCompareVersion(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.0", "1.2.0", 0},
+		{"1.10.0", "1.9.3", 1},
+		{"1.2", "1.2.1", -1},
+		{"1.2.0-beta", "1.2.0-alpha", 1},
+		{"", "0.1", -1},
+	}
+	for _, c := range cases {
+		if got := compareVersion(c.a, c.b); got != c.want {
+			t.Error(c.a, c.b, got)
+		}
+	}
+}
+
+func TestBaseChildStopping(t *testing.T) {
+	fake := NewFakeRunner("fake")
+	if res := fake.Start(map[string]RpcSvr{"svr": &TestSvr{}}, nil); !res.IsOk() {
+		t.Fatal("start:", res)
+	}
+	defer fake.Stop()
+	child := &BaseChild{handler: fake.Runner()}
+
+	// 退出请求与心跳、Hello并发
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var remaining int
+		child.RpcStopChild(0, &remaining)
+		child.RpcStopChild(0, &remaining)
+	}()
+	for i := 0; i < 100; i++ {
+		var alive bool
+		var data []byte
+		child.RpcPing(0, &alive)
+		child.RpcHello(ProtocolVersion, &data)
+	}
+	<-done
+
+	var alive bool
+	if child.RpcPing(0, &alive); alive {
+		t.Fatal("alive after stop")
+	}
+	var data []byte
+	child.RpcHello(ProtocolVersion, &data)
+	hello := &RunnerHello{}
+	if err := json.Unmarshal(data, hello); err != nil || !hello.HasMethod("svr.Multiply") {
+		t.Fatal("hello after stop:", err, hello)
+	}
+}
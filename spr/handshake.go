@@ -0,0 +1,141 @@
+package spr
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/livekit/protocol/logger"
+	"io"
+	"net"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+// 连接建立后, 客户端先发送handshakeReq, 服务端校验后回复handshakeAck, 之后才进入RPC通信
+//
+//	. 帧格式: 4字节大端长度 + JSON
+const (
+	handshakeVersion = 1
+	handshakeTimeout = 5 * time.Second
+	handshakeMaxSize = 64 * 1024
+)
+
+type handshakeReq struct {
+	Version int    `json:"version"`
+	Token   string `json:"token"`
+	Name    string `json:"name"`             // 发起连接的一方名称(子进程名)
+	Codec   string `json:"codec,omitempty"`  // 之后RPC通信使用的编解码方式(空表示gob)
+	Stream  string `json:"stream,omitempty"` // 非空表示该连接用于指定名称的流, 而非RPC通信
+}
+
+type handshakeAck struct {
+	Error string `json:"error,omitempty"`
+}
+
+// clientHandshake 客户端发起握手
+func clientHandshake(conn net.Conn, req *handshakeReq) error {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	req.Version = handshakeVersion
+	if err := writeFrame(conn, req); err != nil {
+		return err
+	}
+
+	var ack handshakeAck
+	if err := readFrame(conn, &ack); err != nil {
+		return err
+	}
+	if ack.Error != "" {
+		return errors.New("handshake rejected: " + ack.Error)
+	}
+	return nil
+}
+
+// serverHandshake 服务端校验握手, 客户端的编解码方式须与codec一致, streams表示是否接受流连接
+//
+//	(tokenOf返回该名称的客户端应使用的密钥, 空表示不允许连接)
+func serverHandshake(conn net.Conn, tokenOf func(name string) string, codec Codec, streams bool) (*handshakeReq, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	var req handshakeReq
+	if err := readFrame(conn, &req); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if req.Version != handshakeVersion {
+		err = errors.New("unsupported handshake version")
+	} else if token := tokenOf(req.Name); token == "" || subtle.ConstantTimeCompare([]byte(req.Token), []byte(token)) != 1 {
+		err = errors.New("invalid token")
+	} else if reqCodec, e := ParseCodec(req.Codec); e != nil || reqCodec != codec {
+		err = errors.New("codec mismatch: server uses " + codec.String() + ", client uses " + codecName(req.Codec))
+	} else if req.Stream != "" && !streams {
+		err = errors.New("stream not accepted: " + req.Stream)
+	}
+	if err != nil {
+		writeFrame(conn, &handshakeAck{Error: err.Error()})
+		return &req, err
+	}
+
+	return &req, writeFrame(conn, &handshakeAck{})
+}
+
+func writeFrame(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	_, err = w.Write(frame)
+	return err
+}
+
+func readFrame(r io.Reader, v any) error {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+
+	size := binary.BigEndian.Uint32(header[:])
+	if size > handshakeMaxSize {
+		return errors.New("frame too large")
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// acceptHandshake 校验新连接的握手, 失败时记录日志、计数并关闭连接
+func acceptHandshake(tag string, conn net.Conn, tokenOf func(name string) string, codec Codec, streams bool,
+	failures *atomic.Int64) (*handshakeReq, bool) {
+	req, err := serverHandshake(conn, tokenOf, codec, streams)
+	if err != nil {
+		name := ""
+		if req != nil {
+			name = req.Name
+		}
+		count := failures.Inc()
+		logger.Warnw(tag+"reject unauthenticated connection", err,
+			"remote", conn.RemoteAddr(), "name", name, "failures", count)
+		conn.Close()
+		return nil, false
+	}
+	return req, true
+}
+
+func codecName(name string) string {
+	if name == "" {
+		return CodecGob.String()
+	}
+	return name
+}
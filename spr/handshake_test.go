@@ -0,0 +1,85 @@
+package spr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/patstar123/go-base"
+	"testing"
+	"time"
+)
+
+func TestReadFrame(t *testing.T) {
+	var buf bytes.Buffer
+	writeFrame(&buf, &handshakeReq{Token: "t", Name: "n"})
+	var req handshakeReq
+	if err := readFrame(&buf, &req); err != nil || req.Token != "t" || req.Name != "n" {
+		t.Fatal("read:", err, req)
+	}
+
+	frames := map[string][]byte{
+		"empty":     {},
+		"header":    {0, 0},
+		"truncated": append(binary.BigEndian.AppendUint32(nil, 100), "{}"...),
+		"oversized": binary.BigEndian.AppendUint32(nil, handshakeMaxSize+1),
+		"max":       {0xff, 0xff, 0xff, 0xff},
+		"not json":  append(binary.BigEndian.AppendUint32(nil, 3), "abc"...),
+	}
+	for name, frame := range frames {
+		if err := readFrame(bytes.NewReader(frame), &req); err == nil {
+			t.Fatal(name, "accepted")
+		}
+	}
+}
+
+func TestHandshakeReject(t *testing.T) {
+	fake := NewFakeRunner("fake")
+	if res := fake.Start(map[string]RpcSvr{"svr": &TestSvr{}}, nil); !res.IsOk() {
+		t.Fatal("start:", res)
+	}
+	defer fake.Stop()
+	runner := fake.Runner()
+
+	caller := &SubProcCaller{}
+	if res := caller.attach("fake", fake.ctx.RpcAddr, "bad", nil); !res.IsEqual(base.ACTION_ILLEGAL) {
+		t.Fatal("wrong token:", res)
+	}
+	if n := runner.AuthFailures(); n != 1 {
+		t.Fatal("failures:", n)
+	}
+
+	// 非法的长度前缀: 连接被关闭, 服务不受影响
+	frames := [][]byte{
+		binary.BigEndian.AppendUint32(nil, handshakeMaxSize+1),
+		append(binary.BigEndian.AppendUint32(nil, 100), "{}"...),
+	}
+	for i, frame := range frames {
+		conn, err := dialAddress(fake.ctx.RpcAddr)
+		if err != nil {
+			t.Fatal("dial:", err)
+		}
+		conn.Write(frame)
+		if i == 0 {
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := conn.Read(make([]byte, 1)); err == nil {
+				t.Fatal("oversized frame not rejected")
+			}
+		}
+		// 内容不足时服务端等待到客户端关闭
+		conn.Close()
+	}
+	for deadline := time.Now().Add(time.Second); runner.AuthFailures() < 3 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := runner.AuthFailures(); n != 3 {
+		t.Fatal("failures:", n)
+	}
+
+	if res := fake.Attach(caller, []RunnerTyper{&TestSvr{}}); !res.IsOk() {
+		t.Fatal("attach:", res)
+	}
+	defer caller.TerminateRunnerFastly()
+	var value int
+	if err := caller.Call("svr.Multiply", &TestArgs{3, 4}, &value); err != nil || value != 12 {
+		t.Fatal("Multiply:", err, value)
+	}
+}
@@ -0,0 +1,107 @@
+package spr
+
+import (
+	"context"
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	"strconv"
+	"time"
+)
+
+// HeartbeatOptions 子进程心跳检测参数
+type HeartbeatOptions struct {
+	Interval  time.Duration // 心跳间隔
+	Timeout   time.Duration // 单次心跳的超时时间
+	MaxMisses int           // 连续丢失多少次心跳后判定子进程失活
+}
+
+func DefaultHeartbeatOptions() *HeartbeatOptions {
+	return &HeartbeatOptions{
+		Interval:  2 * time.Second,
+		Timeout:   time.Second,
+		MaxMisses: 3,
+	}
+}
+
+// parentTimeout 子进程在多长时间内未收到心跳即判定父进程丢失
+func (h *HeartbeatOptions) parentTimeout() time.Duration {
+	return h.Interval*time.Duration(h.MaxMisses+1) + h.Timeout
+}
+
+// SetHeartbeat 设置子进程心跳检测(nil表示不检测)
+//
+//	(需在创建子进程前设置; 子进程失活时将被杀死,并触发ChildUnresponsive事件)
+func (p *SubProcCaller) SetHeartbeat(options *HeartbeatOptions) *SubProcCaller {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.heartbeat = nil
+	if options != nil {
+		// 复制一份再填充默认值, 不修改调用者的参数(进程池的成员共用同一份参数)
+		opts := *options
+		if opts.Interval <= 0 {
+			opts.Interval = DefaultHeartbeatOptions().Interval
+		}
+		if opts.Timeout <= 0 || opts.Timeout > opts.Interval {
+			opts.Timeout = opts.Interval
+		}
+		if opts.MaxMisses <= 0 {
+			opts.MaxMisses = 1
+		}
+		p.heartbeat = &opts
+	}
+	return p
+}
+
+//////////////////////////////////// private functions
+
+func (p *SubProcCaller) heartbeatLoop(options *HeartbeatOptions, quit chan struct{}) {
+	ticker := time.NewTicker(options.Interval)
+	defer ticker.Stop()
+
+	misses := 0
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+
+		p.mutex.Lock()
+		cli, cmd, onEvent := p.cli, p.cmd, p.onEvent
+		p.mutex.Unlock()
+		if cli == nil || cmd == nil {
+			// 子进程重启中, 或为调试附加的子进程
+			misses = 0
+			continue
+		}
+
+		var alive bool
+		ctx, cancel := context.WithTimeout(context.Background(), options.Timeout)
+		err := callContext(ctx, cli, PingMethod, 0, &alive)
+		cancel()
+		if err == nil && alive {
+			misses = 0
+			continue
+		}
+
+		misses++
+		logger.Warnw("[parent]child heartbeat missed: "+p.name, err, "misses", misses)
+		if misses < options.MaxMisses {
+			continue
+		}
+
+		misses = 0
+		res := base.ACTION_TIMEOUT.AppendMsg("child is unresponsive: " + p.name)
+		logger.Warnw("[parent]kill unresponsive child", res)
+		event := &ChildEvent{Type: ChildUnresponsive, Name: p.name, Result: res}
+		if cmd != nil && cmd.Process != nil {
+			event.Pid = cmd.Process.Pid
+			cmd.Process.Kill()
+		}
+		onEvent.On(event)
+	}
+}
+
+func formatMillis(d time.Duration) string {
+	return strconv.FormatInt(d.Milliseconds(), 10)
+}
@@ -0,0 +1,53 @@
+//go:build linux
+
+package spr
+
+import (
+	"github.com/patstar123/go-base"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatUnresponsive(t *testing.T) {
+	events := make(chan *ChildEvent, 16)
+	caller := (&SubProcCaller{}).
+		SetHeartbeat(&HeartbeatOptions{Interval: 50 * time.Millisecond, Timeout: 20 * time.Millisecond, MaxMisses: 3}).
+		SetEventCallback(func(event *ChildEvent) { events <- event })
+	startChild(t, caller, "child", nil)
+
+	var pid int
+	if err := caller.Call("ctl.Pid", 0, &pid); err != nil {
+		t.Fatal("pid:", err)
+	}
+	// 暂停子进程, 心跳不再应答
+	caller.mutex.Lock()
+	cmd := caller.cmd
+	caller.mutex.Unlock()
+	start := time.Now()
+	if err := cmd.Process.Signal(syscall.SIGSTOP); err != nil {
+		t.Fatal("stop:", err)
+	}
+
+	// 杀死后的退出事件可能先于失活事件到达
+	got := map[ChildEventType]*ChildEvent{}
+	for got[ChildUnresponsive] == nil || got[ChildExited] == nil {
+		select {
+		case event := <-events:
+			got[event.Type] = event
+		case <-time.After(5 * time.Second):
+			t.Fatal("events:", got)
+		}
+	}
+	elapsed := time.Since(start)
+	if event := got[ChildUnresponsive]; event == nil || event.Pid != pid || !event.Result.IsEqual(base.ACTION_TIMEOUT) {
+		t.Fatal("unresponsive event:", event)
+	}
+	if event := got[ChildExited]; event == nil || event.Pid != pid || event.Signal != "killed" {
+		t.Fatal("exit event:", event)
+	}
+	// 连续丢失3次心跳后才判定失活
+	if elapsed < 3*50*time.Millisecond {
+		t.Fatal("killed too early:", elapsed)
+	}
+}
@@ -0,0 +1,159 @@
+package spr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	"github.com/patstar123/go-base/utils"
+	"net/rpc"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProtocolVersion spr协议版本
+//
+//	(内置方法或通信协议发生不兼容的变化时递增; 不支持RpcHello的旧版本子进程视为0)
+const ProtocolVersion = 1
+
+// HelloMethod 查询子进程的版本和能力, reply为JSON编码的RunnerHello
+const HelloMethod = BaseChildName + ".RpcHello"
+
+// RunnerHello 子进程的版本和能力
+type RunnerHello struct {
+	Building utils.Building `json:"building"` // 子进程的utils.AppBuilding
+	Protocol int            `json:"protocol"` // 子进程的spr协议版本
+	Methods  []string       `json:"methods"`  // 已注册的服务方法, 如"baseChild.RpcPing"
+}
+
+// HasMethod 子进程是否注册了指定的服务方法
+func (h *RunnerHello) HasMethod(serviceMethod string) bool {
+	for _, method := range h.Methods {
+		if method == serviceMethod {
+			return true
+		}
+	}
+	return false
+}
+
+// MinRunnerVersion 父进程要求的子进程最低版本
+type MinRunnerVersion struct {
+	Protocol    int    // 最低spr协议版本(0表示不要求)
+	VersionName string // 最低应用版本(utils.Building.VersionName, 如"1.2.0"; 空表示不要求)
+}
+
+// SetMinRunnerVersion 设置要求的子进程最低版本(nil表示不校验)
+//
+//	(连接子进程后立即调用RpcHello校验, 不满足时创建失败并返回ACTION_UNSUPPORTED)
+func (p *SubProcCaller) SetMinRunnerVersion(min *MinRunnerVersion) *SubProcCaller {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.minVersion = min
+	return p
+}
+
+// RunnerHello 连接时获取的子进程版本和能力(未连接时为nil)
+func (p *SubProcCaller) RunnerHello() *RunnerHello {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.hello
+}
+
+//////////////////////////////////// private functions
+
+const helloTimeout = 5 * time.Second
+
+// sayHello 获取子进程的版本和能力并按min校验
+func sayHello(cli *rpc.Client, min *MinRunnerVersion) (*RunnerHello, base.Result) {
+	var data []byte
+	ctx, cancel := context.WithTimeout(context.Background(), helloTimeout)
+	err := callContext(ctx, cli, HelloMethod, ProtocolVersion, &data)
+	cancel()
+
+	hello := &RunnerHello{}
+	if err == nil {
+		if err = json.Unmarshal(data, hello); err != nil {
+			return nil, base.REMOTE_SYSTEM_ERROR.AppendErr("decode runner hello failed", err)
+		}
+	} else if isMethodNotFound(err, HelloMethod) {
+		// 旧版本子进程
+		logger.Infow("[parent]child not support " + HelloMethod)
+	} else {
+		return nil, callResult(HelloMethod, err)
+	}
+
+	if min == nil {
+		return hello, base.SUCCESS
+	}
+	if hello.Protocol < min.Protocol {
+		return nil, base.ACTION_UNSUPPORTED.AppendMsg("child protocol " + strconv.Itoa(hello.Protocol) +
+			" < " + strconv.Itoa(min.Protocol))
+	}
+	if min.VersionName != "" && compareVersion(hello.Building.VersionName, min.VersionName) < 0 {
+		return nil, base.ACTION_UNSUPPORTED.AppendMsg("child version '" + hello.Building.VersionName +
+			"' < " + min.VersionName)
+	}
+	return hello, base.SUCCESS
+}
+
+// isMethodNotFound err是否为net/rpc服务端找不到serviceMethod的错误
+func isMethodNotFound(err error, serviceMethod string) bool {
+	var serverErr rpc.ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+	msg := string(serverErr)
+	return msg == "rpc: can't find service "+serviceMethod || msg == "rpc: can't find method "+serviceMethod
+}
+
+// compareVersion 按'.'分段比较版本号, 数字段按数值比较, 其余按字符串比较
+func compareVersion(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y string
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+		xn, xErr := strconv.Atoi(x)
+		yn, yErr := strconv.Atoi(y)
+		switch {
+		case x == y:
+			continue
+		case xErr == nil && yErr == nil:
+			if xn < yn {
+				return -1
+			} else if xn > yn {
+				return 1
+			}
+		case x < y:
+			return -1
+		default:
+			return 1
+		}
+	}
+	return 0
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// serviceMethods 按net/rpc的规则列出服务的方法
+func serviceMethods(rpcName string, rpcSvr any) []string {
+	var methods []string
+	typ := reflect.TypeOf(rpcSvr)
+	for i := 0; i < typ.NumMethod(); i++ {
+		method := typ.Method(i)
+		mtype := method.Type
+		if !method.IsExported() || mtype.NumIn() != 3 || mtype.NumOut() != 1 ||
+			mtype.In(2).Kind() != reflect.Pointer || mtype.Out(0) != errorType {
+			continue
+		}
+		methods = append(methods, rpcName+"."+method.Name)
+	}
+	return methods
+}
@@ -0,0 +1,123 @@
+package spr
+
+import (
+	"github.com/patstar123/go-base"
+	"os"
+	"syscall"
+)
+
+// LaunchOptions 子进程启动参数
+type LaunchOptions struct {
+	CallbackListener *SubProcCBListener // 回调监听器(可为nil)
+	Env              []string           // 额外的环境变量, 格式为"KEY=VALUE"
+	Dir              string             // 工作目录(空表示继承父进程)
+	Args             []string           // 应用自定义参数, 子进程通过RunnerContext.Args获取
+	Rlimits          []Rlimit           // 资源限制, 子进程启动后立即设置(仅Linux)
+	Setpgid          bool               // 是否使用独立的进程组(仅Linux)
+	Pdeathsig        syscall.Signal     // 父进程退出时子进程收到的信号, 0表示不设置(仅Linux, 见下方说明)
+}
+
+// Pdeathsig 说明
+//
+//	. Linux的Pdeathsig绑定的是启动子进程的那个线程, 而非父进程
+//	. Go程序中该线程退出(如调用过runtime.LockOSThread的协程未解锁就结束)时, 即使父进程仍在运行, 子进程也会收到信号
+//	. 需要可靠的父进程存活检测时, 请配合SetHeartbeat使用(子进程在父进程超时后自行退出)
+
+// Rlimit 子进程资源限制, Resource取值如syscall.RLIMIT_NOFILE
+type Rlimit struct {
+	Resource int
+	Cur      uint64
+	Max      uint64
+}
+
+// CreateAndConnectRunnerWithOptions 按启动参数创建并连接子进程
+func (p *SubProcCaller) CreateAndConnectRunnerWithOptions(nameSrc, programSrc string,
+	typers []RunnerTyper, options *LaunchOptions) base.Result {
+	if options == nil {
+		options = &LaunchOptions{}
+	}
+	cbAddr := ""
+	if options.CallbackListener != nil {
+		cbAddr = options.CallbackListener.GetAddress()
+	}
+	return p.create(nameSrc, programSrc, typers, cbAddr, options)
+}
+
+// RunnerContext 子进程的启动上下文
+//
+//	. 命令行格式: <program> <name> <logLevel> <rpcAddr> <cbAddr> [-- <args>...]
+type RunnerContext struct {
+	Name          string   // 子进程名
+	LogLevel      string   // 日志级别
+	RpcAddr       string   // RPC服务地址(端口号或"unix:"+套接字路径)
+	CallbackAddr  string   // 回调监听器地址(空表示无回调)
+	Args          []string // 应用自定义参数
+	Codec         Codec    // RPC服务的编解码方式
+	CallbackCodec Codec    // 回调的编解码方式
+
+	token         string
+	cbToken       string
+	parentTimeout string
+}
+
+// ParseRunnerContext 从命令行参数(通常为os.Args)和环境变量中解析启动上下文
+func ParseRunnerContext(args []string) (*RunnerContext, base.Result) {
+	if len(args) < 2 {
+		return nil, base.INVALID_PARAM.AppendMsg("lost command params: child name")
+	}
+	if len(args) < 3 {
+		return nil, base.INVALID_PARAM.AppendMsg("lost command params: log level")
+	}
+	if len(args) < 4 {
+		return nil, base.INVALID_PARAM.AppendMsg("lost command params: rpc address")
+	}
+
+	ctx := &RunnerContext{
+		Name:     args[1],
+		LogLevel: args[2],
+		RpcAddr:  args[3],
+	}
+	rest := args[4:]
+	if len(rest) > 0 && rest[0] != argsSeparator {
+		ctx.CallbackAddr = rest[0]
+		rest = rest[1:]
+	}
+	if len(rest) > 0 && rest[0] == argsSeparator {
+		ctx.Args = rest[1:]
+	}
+
+	if err := checkAddress(ctx.RpcAddr); err != nil {
+		return nil, base.INVALID_PARAM.AppendErr("invalid rpc address: "+ctx.RpcAddr, err)
+	}
+	if ctx.CallbackAddr != "" {
+		if err := checkAddress(ctx.CallbackAddr); err != nil {
+			return nil, base.INVALID_PARAM.AppendErr("invalid callback address: "+ctx.CallbackAddr, err)
+		}
+	}
+
+	ctx.token = takeEnv(envToken)
+	ctx.cbToken = takeEnv(envCallbackToken)
+	ctx.parentTimeout = takeEnv(envParentTimeout)
+
+	var err error
+	if ctx.Codec, err = ParseCodec(os.Getenv(envCodec)); err != nil {
+		return nil, base.INVALID_PARAM.AppendErr("invalid "+envCodec, err)
+	}
+	if ctx.CallbackCodec, err = ParseCodec(os.Getenv(envCallbackCodec)); err != nil {
+		return nil, base.INVALID_PARAM.AppendErr("invalid "+envCallbackCodec, err)
+	}
+	return ctx, base.SUCCESS
+}
+
+// 保留参数与应用自定义参数之间的分隔符
+const argsSeparator = "--"
+
+// runnerArgs 生成子进程的命令行参数(不含程序名)
+func runnerArgs(name, level, rpcAddr, cbAddr string, extra []string) []string {
+	args := []string{name, level, rpcAddr, cbAddr}
+	if len(extra) > 0 {
+		args = append(args, argsSeparator)
+		args = append(args, extra...)
+	}
+	return args
+}
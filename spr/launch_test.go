@@ -0,0 +1,26 @@
+package spr
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseRunnerContextTakeEnv(t *testing.T) {
+	t.Setenv(envToken, "token")
+	t.Setenv(envParentTimeout, "1500")
+
+	ctx, res := ParseRunnerContext([]string{"runner", "child", "info", "12345"})
+	if !res.IsOk() {
+		t.Fatal("parse:", res)
+	}
+	if ctx.token != "token" || ctx.parentTimeoutValue() != 1500*time.Millisecond {
+		t.Fatal("context:", ctx.token, ctx.parentTimeout)
+	}
+	// 不被子进程自己启动的子进程继承
+	for _, key := range []string{envToken, envParentTimeout} {
+		if _, ok := os.LookupEnv(key); ok {
+			t.Fatal("env not taken:", key)
+		}
+	}
+}
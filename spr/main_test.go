@@ -0,0 +1,101 @@
+package spr
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// envTestRunner 测试程序以该环境变量启动时作为子进程运行
+const envTestRunner = "SPR_TEST_RUNNER"
+
+// testRunner 作为子进程运行时的执行器
+var testRunner = &SubProcRunner{}
+
+// TestCtl 子进程中用于测试的控制服务
+type TestCtl struct{}
+
+func (c *TestCtl) CustomTypeValues() []any {
+	return nil
+}
+
+// Exit 模拟子进程崩溃
+func (c *TestCtl) Exit(code int, reply *int) error {
+	os.Exit(code)
+	return nil
+}
+
+// Pid 子进程的pid
+func (c *TestCtl) Pid(args int, reply *int) error {
+	*reply = os.Getpid()
+	return nil
+}
+
+// Sleep 等待args毫秒后返回子进程的pid
+func (c *TestCtl) Sleep(args int, reply *int) error {
+	time.Sleep(time.Duration(args) * time.Millisecond)
+	*reply = os.Getpid()
+	return nil
+}
+
+// Print 向标准输出打印args(不追加换行)
+func (c *TestCtl) Print(args string, reply *int) error {
+	*reply, _ = os.Stdout.WriteString(args)
+	return nil
+}
+
+// Publish 发布主题为"test"的事件
+func (c *TestCtl) Publish(args string, reply *int) error {
+	if res := testRunner.Publish("test", args); !res.IsOk() {
+		return res
+	}
+	return nil
+}
+
+// IgnoreStop 模拟不响应退出的子进程: 退出钩子不返回且忽略SIGTERM
+func (c *TestCtl) IgnoreStop(args int, reply *int) error {
+	signal.Ignore(syscall.SIGTERM)
+	testRunner.SetShutdownHook(func(drained bool) { select {} })
+	return nil
+}
+
+func TestMain(m *testing.M) {
+	if takeEnv(envTestRunner) != "" {
+		res := testRunner.Run(map[string]RpcSvr{"svr": &TestSvr{}, "ctl": &TestCtl{}}, nil)
+		if !res.IsOk() {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// startChild 以测试程序本身作为子进程启动并连接
+func startChild(t *testing.T, caller *SubProcCaller, name string, options *LaunchOptions) {
+	if options == nil {
+		options = &LaunchOptions{}
+	}
+	options.Env = append(options.Env, envTestRunner+"=1")
+	if res := caller.CreateAndConnectRunnerWithOptions(name, os.Args[0], []RunnerTyper{&TestSvr{}}, options); !res.IsOk() {
+		t.Fatal("create:", res)
+	}
+	t.Cleanup(func() { caller.TerminateRunnerFastly() })
+}
+
+// waitEvent 等待指定类型的子进程事件
+func waitEvent(t *testing.T, events chan *ChildEvent, typ ChildEventType) *ChildEvent {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Type == typ {
+				return event
+			}
+		case <-timeout:
+			t.Fatal("wait event timeout:", typ)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package spr
+
+import (
+	"bytes"
+	"github.com/livekit/protocol/logger"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// OutputOptions 子进程标准输出/错误的捕获参数
+type OutputOptions struct {
+	LogFile     string // 非空时写入该文件(按大小轮转), 否则通过父进程logger输出
+	MaxFileSize int64  // 单个日志文件的最大字节数(<=0表示10MB)
+	MaxBackups  int    // 保留的轮转文件个数(<=0表示3)
+	TailLines   int    // 保留最近输出的行数, 随ChildExited事件上报(<=0表示50)
+}
+
+// SetOutputCapture 设置通过管道捕获子进程的标准输出/错误(nil表示直接继承父进程的)
+//
+//	(需在创建子进程前设置)
+func (p *SubProcCaller) SetOutputCapture(options *OutputOptions) *SubProcCaller {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.output = options
+	return p
+}
+
+//////////////////////////////////// private functions
+
+// maxLineSize 单行的最大字节数, 超过时先输出不完整的一行, 避免没有换行的输出占用过多内存
+const maxLineSize = 64 * 1024
+
+// childOutput 单次启动的子进程输出
+type childOutput struct {
+	mutex   sync.Mutex
+	name    string
+	file    *rotateFile
+	tail    []string
+	next    int
+	filled  bool
+	writers []*lineWriter
+}
+
+func newChildOutput(name string, options *OutputOptions) *childOutput {
+	tailLines := options.TailLines
+	if tailLines <= 0 {
+		tailLines = 50
+	}
+
+	o := &childOutput{name: name, tail: make([]string, tailLines)}
+	if options.LogFile != "" {
+		o.file = newRotateFile(options.LogFile, options.MaxFileSize, options.MaxBackups)
+	}
+	return o
+}
+
+func (o *childOutput) writer(stream string) *lineWriter {
+	w := &lineWriter{output: o, stream: stream}
+	o.writers = append(o.writers, w)
+	return w
+}
+
+func (o *childOutput) onLine(stream, line string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.tail[o.next] = line
+	o.next = (o.next + 1) % len(o.tail)
+	if o.next == 0 {
+		o.filled = true
+	}
+
+	if o.file != nil {
+		o.file.writeLine(line)
+	} else {
+		logger.Infow(line, "child", o.name, "stream", stream)
+	}
+}
+
+// lastLines 最近输出的若干行(按时间顺序)
+func (o *childOutput) lastLines() []string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if !o.filled {
+		return append([]string{}, o.tail[:o.next]...)
+	}
+	lines := make([]string, 0, len(o.tail))
+	lines = append(lines, o.tail[o.next:]...)
+	return append(lines, o.tail[:o.next]...)
+}
+
+// finish 子进程退出后输出剩余内容并关闭文件, 返回最近输出的若干行
+func (o *childOutput) finish() []string {
+	for _, w := range o.writers {
+		w.flush()
+	}
+
+	o.mutex.Lock()
+	if o.file != nil {
+		o.file.close()
+	}
+	o.mutex.Unlock()
+	return o.lastLines()
+}
+
+// lineWriter 按行切分子进程输出
+//
+//	(由exec.Cmd的拷贝协程调用, 子进程退出时cmd.Wait会等待其写完)
+type lineWriter struct {
+	output  *childOutput
+	stream  string
+	pending []byte
+}
+
+func (w *lineWriter) Write(data []byte) (int, error) {
+	n := len(data)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		line := data[:i]
+		if len(w.pending) > 0 {
+			line = append(w.pending, line...)
+			w.pending = nil
+		}
+		w.emit(bytes.TrimSuffix(line, []byte{'\r'}))
+		data = data[i+1:]
+	}
+	if len(data) > 0 {
+		w.pending = append(w.pending, data...)
+	}
+	if len(w.pending) >= maxLineSize {
+		w.emit(w.pending)
+		w.pending = nil
+	}
+	return n, nil
+}
+
+// flush 输出最后不完整的一行
+func (w *lineWriter) flush() {
+	if len(w.pending) > 0 {
+		w.emit(w.pending)
+		w.pending = nil
+	}
+}
+
+// emit 输出一行, 超过maxLineSize时拆分为多行
+func (w *lineWriter) emit(line []byte) {
+	for len(line) > maxLineSize {
+		w.output.onLine(w.stream, string(line[:maxLineSize]))
+		line = line[maxLineSize:]
+	}
+	w.output.onLine(w.stream, string(line))
+}
+
+// rotateFile 按大小轮转的日志文件: path, path.1, path.2 ...
+type rotateFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func newRotateFile(path string, maxSize int64, maxBackups int) *rotateFile {
+	if maxSize <= 0 {
+		maxSize = 10 * 1024 * 1024
+	}
+	if maxBackups <= 0 {
+		maxBackups = 3
+	}
+	return &rotateFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+}
+
+func (f *rotateFile) writeLine(line string) {
+	if f.file == nil && !f.open() {
+		return
+	}
+	if f.size+int64(len(line))+1 > f.maxSize && f.size > 0 {
+		f.rotate()
+		if f.file == nil {
+			return
+		}
+	}
+
+	n, err := f.file.WriteString(line + "\n")
+	f.size += int64(n)
+	if err != nil {
+		logger.Warnw("[parent]write child output failed: "+f.path, err)
+	}
+}
+
+func (f *rotateFile) open() bool {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		logger.Warnw("[parent]open child output file failed: "+f.path, err)
+		return false
+	}
+	info, err := file.Stat()
+	if err == nil {
+		f.size = info.Size()
+	}
+	f.file = file
+	return true
+}
+
+func (f *rotateFile) rotate() {
+	f.close()
+	for i := f.maxBackups - 1; i > 0; i-- {
+		os.Rename(f.path+"."+strconv.Itoa(i), f.path+"."+strconv.Itoa(i+1))
+	}
+	os.Rename(f.path, f.path+".1")
+	f.open()
+}
+
+func (f *rotateFile) close() {
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+		f.size = 0
+	}
+}
@@ -0,0 +1,83 @@
+package spr
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestOutputCapture(t *testing.T) {
+	events := make(chan *ChildEvent, 16)
+	logFile := filepath.Join(t.TempDir(), "child.log")
+	caller := (&SubProcCaller{}).SetOutputCapture(&OutputOptions{LogFile: logFile, TailLines: 3}).
+		SetEventCallback(func(event *ChildEvent) { events <- event })
+	startChild(t, caller, "child", nil)
+
+	var reply int
+	for _, text := range []string{"line1\nline2\r\n", "li", "ne3\nline4\n", "partial"} {
+		if err := caller.Call("ctl.Print", text, &reply); err != nil {
+			t.Fatal("print:", err)
+		}
+	}
+	caller.Call("ctl.Exit", 1, &reply)
+
+	// 退出事件携带最近的3行, 包括最后不完整的一行
+	event := waitEvent(t, events, ChildExited)
+	if want := []string{"line3", "line4", "partial"}; !reflect.DeepEqual(event.Output, want) {
+		t.Fatal("tail:", event.Output)
+	}
+	data, err := os.ReadFile(logFile)
+	if err != nil || !strings.HasSuffix(string(data), "line1\nline2\nline3\nline4\npartial\n") {
+		t.Fatal("log file:", err, string(data))
+	}
+}
+
+func TestOutputLongLine(t *testing.T) {
+	output := newChildOutput("child", &OutputOptions{LogFile: filepath.Join(t.TempDir(), "child.log")})
+	w := output.writer("stdout")
+
+	// 没有换行的输出在达到上限时先输出
+	chunk := strings.Repeat("x", 1000)
+	for i := 0; i < maxLineSize/len(chunk)+1; i++ {
+		w.Write([]byte(chunk))
+	}
+	if len(w.pending) != 0 {
+		t.Fatal("pending:", len(w.pending))
+	}
+	w.Write([]byte(strings.Repeat("y", 2*maxLineSize+1) + "\nz"))
+
+	lines := output.finish()
+	sizes := make([]int, len(lines))
+	for i, line := range lines {
+		sizes[i] = len(line)
+	}
+	total := (maxLineSize/len(chunk) + 1) * len(chunk)
+	want := []int{maxLineSize, total - maxLineSize, maxLineSize, maxLineSize, 1, 1}
+	if !reflect.DeepEqual(sizes, want) {
+		t.Fatal("lines:", sizes)
+	}
+}
+
+func TestOutputRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "child.log")
+	f := newRotateFile(path, 20, 2)
+	for i := 0; i < 10; i++ {
+		f.writeLine("line" + strconv.Itoa(i) + "___") // 每行10字节
+	}
+	f.close()
+
+	// 每个文件2行, 只保留2个轮转文件
+	files := map[string]string{path: "line8___\nline9___\n", path + ".1": "line6___\nline7___\n",
+		path + ".2": "line4___\nline5___\n"}
+	for name, want := range files {
+		if data, err := os.ReadFile(name); err != nil || string(data) != want {
+			t.Fatal(name, err, string(data))
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatal("too many backups:", err)
+	}
+}
@@ -1,26 +1,100 @@
 package spr
 
 import (
-	"fmt"
+	"context"
 	"github.com/patstar123/go-base"
+	"github.com/patstar123/go-base/utils"
+	"net"
 	"net/rpc"
 	"os"
 	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/livekit/protocol/logger"
+	"go.uber.org/atomic"
 )
 
 // SubProcCaller 子进程控制器
 type SubProcCaller struct {
-	name string
-	cli  *rpc.Client
-	cmd  *exec.Cmd
+	mutex  sync.Mutex
+	name   string
+	cli    *rpc.Client
+	cmd    *exec.Cmd
+	exited chan struct{} // 当前子进程退出时关闭
+
+	// 当前子进程的连接参数(用于打开流)
+	rpcAddr string
+	token   string
+	release func() // 调试附加时, 终止后清理监听地址
+
+	// 启动参数(用于重启子进程)
+	program      string
+	typers       []RunnerTyper
+	callbackAddr string
+	options      *LaunchOptions
+	transport    Transport
+	codec        Codec
+
+	policy       *RestartPolicy
+	heartbeat    *HeartbeatOptions
+	monitor      *ResourceMonitor
+	minVersion   *MinRunnerVersion
+	hello        *RunnerHello
+	output       *OutputOptions
+	debug        *DebugAttach
+	onEvent      ChildEventCallback
+	quit         chan struct{}
+	restarting   bool
+	restartTimes []time.Time
+
+	closeOnTimeout atomic.Bool
+	killOnTimeout  atomic.Bool
 }
 
 func (p *SubProcCaller) CreateAndConnectRunner(nameSrc, programSrc string,
 	typers []RunnerTyper, callbackPort int) base.Result {
-	if p.cli != nil || p.cmd != nil {
+	cbAddr := ""
+	if callbackPort > 0 {
+		cbAddr = strconv.Itoa(callbackPort)
+	}
+	return p.create(nameSrc, programSrc, typers, cbAddr, &LaunchOptions{})
+}
+
+// CreateAndConnectRunner2 创建并连接子进程, 回调地址取自cbListener(可为nil)
+//
+//	(支持Unix域套接字等非TCP传输方式)
+func (p *SubProcCaller) CreateAndConnectRunner2(nameSrc, programSrc string,
+	typers []RunnerTyper, cbListener *SubProcCBListener) base.Result {
+	return p.CreateAndConnectRunnerWithOptions(nameSrc, programSrc, typers,
+		&LaunchOptions{CallbackListener: cbListener})
+}
+
+// SetCodec 设置子进程RPC服务的编解码方式(默认CodecGob)
+//
+//	(通过环境变量SPR_CODEC告知子进程; 子进程使用其他编解码方式时握手失败)
+func (p *SubProcCaller) SetCodec(codec Codec) *SubProcCaller {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.codec = codec
+	return p
+}
+
+// SetTransport 设置子进程RPC服务的传输方式(默认TransportTCP)
+func (p *SubProcCaller) SetTransport(transport Transport) *SubProcCaller {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.transport = transport
+	return p
+}
+
+func (p *SubProcCaller) create(nameSrc, programSrc string,
+	typers []RunnerTyper, cbAddr string, options *LaunchOptions) base.Result {
+	p.mutex.Lock()
+	if p.cli != nil || p.cmd != nil || p.restarting {
+		p.mutex.Unlock()
 		res := base.LOGICAL_ERROR.AppendMsg("has been created")
 		logger.Warnw("[parent]CreateAndConnectRunner failed for "+nameSrc, res)
 		return res
@@ -29,32 +103,71 @@ func (p *SubProcCaller) CreateAndConnectRunner(nameSrc, programSrc string,
 	var err error
 	name, err := p.safeCheck(nameSrc)
 	if err != nil {
+		p.mutex.Unlock()
 		res := base.LOGICAL_ERROR.AppendMsg(err.Error())
 		logger.Warnw("[parent]CreateAndConnectRunner safeCheck failed for "+nameSrc, res)
 		return res
 	}
 	program, err := p.safeCheck(programSrc)
 	if err != nil {
+		p.mutex.Unlock()
 		res := base.LOGICAL_ERROR.AppendMsg(err.Error())
 		logger.Warnw("[parent]CreateAndConnectRunner safeCheck failed for "+programSrc, res)
 		return res
 	}
 
 	p.name = name
+	p.program = program
+	p.typers = typers
+	p.callbackAddr = cbAddr
+	p.options = options
+	p.resetQuit()
+	p.restartTimes = nil
+	quit := p.quit
+	heartbeat := p.heartbeat
+	monitor := p.monitor
+	p.mutex.Unlock()
+
+	res := p.launch(quit)
+	if !res.IsOk() {
+		p.stopLoops(quit)
+		return res
+	}
+	if heartbeat != nil {
+		go p.heartbeatLoop(heartbeat, quit)
+	}
+	if monitor != nil {
+		go p.monitorLoop(monitor, quit)
+	}
+	return res
+}
 
-	// 查找可用rpc端口
-	port := findAvailablePort()
-	if port <= 0 {
-		res := base.INTERNAL_ERROR.AppendMsg("has no available rpc port")
+// launch 启动子进程并连接其RPC服务
+func (p *SubProcCaller) launch(quit chan struct{}) base.Result {
+	p.mutex.Lock()
+	name := p.name
+	program := p.program
+	heartbeat := p.heartbeat
+	transport := p.transport
+	codec := p.codec
+	cbAddr := p.callbackAddr
+	options := p.options
+	outputOptions := p.output
+	debug := debugAttachOf(p.name, p.debug)
+	minVersion := p.minVersion
+	p.mutex.Unlock()
+
+	// 分配rpc地址
+	rpcAddr, cleanup, res := newAddress(transport, "rpc")
+	if !res.IsOk() {
+		res = res.AppendMsg("has no available rpc address")
 		logger.Warnw("[parent]CreateAndConnectRunner failed for "+name, res)
 		return res
 	}
-	rpcPort := fmt.Sprintf("%v", port)
 
-	// 回调端口
-	cbPort := ""
-	if callbackPort > 0 {
-		cbPort = fmt.Sprintf("%v", callbackPort)
+	if debug != nil && debug.RpcAddr != "" {
+		cleanup()
+		rpcAddr, cleanup = debug.RpcAddr, func() {}
 	}
 
 	level := logger.GetLogLevel()
@@ -62,63 +175,167 @@ func (p *SubProcCaller) CreateAndConnectRunner(nameSrc, programSrc string,
 		level = "info"
 	}
 
+	// 握手密钥通过环境变量传递给子进程, 避免出现在命令行参数中
+	token := utils.RandomSecret()
+	env := append([]string{}, options.Env...)
+	env = append(env, envToken+"="+token, envCodec+"="+codec.String())
+	if cbAddr != "" {
+		cbToken, cbCodec := issueListenerToken(cbAddr, name)
+		env = append(env, envCallbackToken+"="+cbToken, envCallbackCodec+"="+cbCodec.String())
+		// 子进程退出或启动失败时收回密钥
+		release := cleanup
+		cleanup = func() {
+			revokeListenerToken(cbAddr, name, cbToken)
+			release()
+		}
+	}
+	// 总是显式设置, 避免子进程继承本进程(作为子进程时)的父进程超时
+	parentTimeout := ""
+	if heartbeat != nil && debug == nil {
+		parentTimeout = formatMillis(heartbeat.parentTimeout())
+	}
+	env = append(env, envParentTimeout+"="+parentTimeout)
+
+	var err error
 	var cmd *exec.Cmd
-	if !debugEnabled {
+	var exited chan struct{}
+	var release func()
+	connectTimeout := 5 * time.Second
+	if debug == nil {
 		// 启动子进程
 		logger.Infow("[parent]try to start child process", "name", name,
-			"log", level, "rpcPort", rpcPort, "cbPort", cbPort)
-		cmd = exec.Command(program, name, level, rpcPort, cbPort)
+			"log", level, "rpcAddr", rpcAddr, "cbAddr", cbAddr, "args", options.Args)
+		cmd = exec.Command(program, runnerArgs(name, level, rpcAddr, cbAddr, options.Args)...)
+		cmd.Dir = options.Dir
+		applySysProcAttr(cmd, options)
 		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		var output *childOutput
+		if outputOptions != nil {
+			output = newChildOutput(name, outputOptions)
+			cmd.Stdout = output.writer("stdout")
+			cmd.Stderr = output.writer("stderr")
+			// 孙进程继承管道时避免Wait一直阻塞
+			cmd.WaitDelay = time.Second
+		} else {
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+		}
+		cmd.Env = append(os.Environ(), env...)
 		err = cmd.Start()
 		if err != nil {
+			cleanup()
 			res := base.INTERNAL_ERROR.AppendErr("start child process failed", err)
 			logger.Warnw("[parent]CreateAndConnectRunner failed for "+name, res)
 			return res
 		}
+		exited = make(chan struct{})
+		go p.watch(cmd, exited, cleanup, output)
+
+		if err = applyRlimits(cmd.Process.Pid, options.Rlimits); err != nil {
+			cmd.Process.Kill()
+			res := base.INTERNAL_ERROR.AppendErr("set child rlimits failed", err)
+			logger.Warnw("[parent]CreateAndConnectRunner failed for "+name, res)
+			return res
+		}
 	} else {
-		rpcPort = debugRpcPort
-		cbPort = debugCallbackPort
-		logger.Warnw("!!!debug:"+program+" "+name+" "+level+" "+rpcPort+" "+cbPort, nil)
+		// 调试附加, 等待手动启动
+		release = cleanup
+		connectTimeout = debug.timeout()
+		logger.Warnw("[parent]debug attach "+name+", start it manually in "+connectTimeout.String()+":\n"+
+			commandLine(options.Dir, env, program, runnerArgs(name, level, rpcAddr, cbAddr, options.Args)), nil)
+	}
+
+	// 启动失败时清理
+	abort := func() {
+		if cmd != nil && cmd.Process != nil {
+			cmd.Process.Kill()
+		}
+		if release != nil {
+			release()
+		}
 	}
 
 	// 连接子进程RPC服务
 	var client *rpc.Client
-	for i := 0; i < 250; i++ {
-		client, err = rpc.Dial("tcp", "localhost:"+rpcPort)
+	connectStart := time.Now()
+	for i := 0; ; i++ {
+		var conn net.Conn
+		conn, err = dialAddress(rpcAddr)
 		if err == nil {
+			err = clientHandshake(conn, &handshakeReq{Token: token, Name: name, Codec: codec.String()})
+			if err != nil {
+				conn.Close()
+				res := base.ACTION_ILLEGAL.AppendErr("child process handshake failed", err)
+				logger.Warnw("[parent]CreateAndConnectRunner failed for "+name, res)
+				abort()
+				return res
+			}
+			client = newClient(codec, conn)
 			break
 		}
 
-		if i < 3 { // 100ms
-			logger.Debugw("connect child process rpc port failed, try later")
+		if time.Since(connectStart) >= connectTimeout {
+			break
+		}
+		if debug != nil {
+			// 等待手动启动期间不输出重试日志
+		} else if i < 3 { // 100ms
+			logger.Debugw("connect child process rpc address failed, try later")
 		} else if i < 20 { // 1000ms
-			logger.Infow("connect child process rpc port failed, try later")
+			logger.Infow("connect child process rpc address failed, try later")
 		} else {
-			logger.Warnw("connect child process rpc port failed, try later", nil)
+			logger.Warnw("connect child process rpc address failed, try later", nil)
 		}
 		time.Sleep(50 * time.Millisecond)
 	}
 	if err != nil {
-		res := base.INTERNAL_ERROR.AppendErr("connect child process rpc port failed after 5s", err)
+		res := base.INTERNAL_ERROR.AppendErr("connect child process rpc address failed after "+connectTimeout.String(), err)
 		logger.Warnw("[parent]CreateAndConnectRunner failed for "+name, res)
-		if cmd != nil && cmd.Process != nil {
-			cmd.Process.Kill()
-			go cmd.Wait()
-		}
+		abort()
+		return res
+	}
+
+	// 校验子进程版本
+	hello, res := sayHello(client, minVersion)
+	if !res.IsOk() {
+		client.Close()
+		logger.Warnw("[parent]CreateAndConnectRunner failed for "+name, res)
+		abort()
 		return res
 	}
 
 	// 注册远端的类型到Rpc服务
-	if typers != nil {
-		for _, typer := range typers {
+	if p.typers != nil {
+		for _, typer := range p.typers {
 			LoadRpcTypes(typer)
 		}
 	}
 
+	p.mutex.Lock()
+	select {
+	case <-quit:
+		// 启动过程中已被终止
+		p.mutex.Unlock()
+		client.Close()
+		abort()
+		return base.ACTION_CANCELED.AppendMsg("terminated while starting: " + name)
+	default:
+	}
 	p.cmd = cmd
+	p.exited = exited
 	p.cli = client
+	p.hello = hello
+	p.rpcAddr, p.token, p.release = rpcAddr, token, release
+	p.restarting = false
+	onEvent := p.onEvent
+	restarts := len(p.restartTimes)
+	p.mutex.Unlock()
+
+	event := &ChildEvent{Type: ChildStarted, Name: name, Restarts: restarts}
+	if cmd != nil && cmd.Process != nil {
+		event.Pid = cmd.Process.Pid
+	}
+	onEvent.On(event)
 	return base.SUCCESS
 }
 
@@ -126,58 +343,261 @@ func (p *SubProcCaller) safeCheck(input string) (string, error) {
 	return input, nil
 }
 
-func (p *SubProcCaller) TerminateRunnerSafely() {
-	if p.cli == nil {
+// DefaultStopDeadline 默认的子进程优雅退出期限
+const DefaultStopDeadline = 5 * time.Second
+
+// stopGrace 优雅退出期限之外, 留给停止请求应答及SIGTERM处理的时间
+const stopGrace = time.Second
+
+// TerminateRunnerSafely 通知子进程优雅退出
+//
+//	(子进程停止接受新连接, 在deadline内等待进行中的调用完成并执行退出钩子;
+//	 超过期限仍未退出时依次发送SIGTERM和SIGKILL)
+func (p *SubProcCaller) TerminateRunnerSafely(deadline time.Duration) {
+	if deadline < 0 {
+		deadline = 0
+	}
+	cli, cmd, exited := p.detach()
+	if cli == nil {
 		return
 	}
 
-	logger.Infow("[parent]try to stop child: " + p.name)
-	var replay int
-	err := p.cli.Call(StopMethod, 0, &replay)
+	logger.Infow("[parent]try to stop child: "+p.name, "deadline", deadline)
+	until := time.Now().Add(deadline + stopGrace)
+	var remaining int
+	ctx, cancel := context.WithDeadline(context.Background(), until)
+	err := callContext(ctx, cli, StopMethod, int(deadline.Milliseconds()), &remaining)
+	cancel()
+	cli.Close()
 	if err != nil {
-		logger.Warnw("[parent]stop child failed, force to kill it: "+p.name, err)
-		if p.cmd != nil && p.cmd.Process != nil {
-			p.cmd.Process.Kill()
-		}
+		logger.Warnw("[parent]stop child failed: "+p.name, err)
+	} else if remaining > 0 {
+		logger.Warnw("[parent]child stopped with unfinished calls: "+p.name, nil, "remaining", remaining)
 	} else {
 		logger.Infow("[parent]child stopped: " + p.name)
 	}
 
-	if p.cmd != nil {
-		cmd := p.cmd
-		go cmd.Wait()
-		p.cmd = nil
-	}
-
-	p.cli.Close()
-	p.cli = nil
+	p.waitExit(cmd, exited, until)
 }
 
 func (p *SubProcCaller) TerminateRunnerFastly() {
-	if p.cmd != nil {
+	cli, cmd, _ := p.detach()
+	if cmd != nil {
 		logger.Infow("[parent]force to kill child: " + p.name)
-		if p.cmd.Process != nil {
-			p.cmd.Process.Kill()
+		if cmd.Process != nil {
+			cmd.Process.Kill()
 		}
-		cmd := p.cmd
-		go cmd.Wait()
-		p.cmd = nil
 	}
 
-	if p.cli != nil {
-		p.cli.Close()
-		p.cli = nil
+	if cli != nil {
+		cli.Close()
+	}
+}
+
+// detach 解除与当前子进程的关联并停止自动重启
+//
+//	(子进程由watch协程回收)
+func (p *SubProcCaller) detach() (*rpc.Client, *exec.Cmd, chan struct{}) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.quit != nil {
+		close(p.quit)
+		p.quit = nil
+	}
+	p.restarting = false
+
+	cli, cmd, exited := p.cli, p.cmd, p.exited
+	p.cli = nil
+	p.cmd = nil
+	p.exited = nil
+	p.hello = nil
+	if p.release != nil {
+		p.release()
+		p.release = nil
+	}
+	return cli, cmd, exited
+}
+
+// resetQuit 结束上一个子进程的心跳/监控协程, 为新的子进程创建quit(调用时需持有锁)
+func (p *SubProcCaller) resetQuit() {
+	if p.quit != nil {
+		close(p.quit)
 	}
+	p.quit = make(chan struct{})
+}
+
+// stopLoops 不再重启时结束quit对应的心跳/监控协程
+//
+//	(quit已被替换或关闭时不处理)
+func (p *SubProcCaller) stopLoops(quit chan struct{}) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.quit == quit && quit != nil {
+		close(p.quit)
+		p.quit = nil
+	}
+}
+
+// waitExit 等待子进程退出, 超过until后发送SIGTERM, 再超过stopGrace后强制杀死
+func (p *SubProcCaller) waitExit(cmd *exec.Cmd, exited chan struct{}, until time.Time) {
+	if cmd == nil || cmd.Process == nil || exited == nil {
+		return
+	}
+
+	timer := time.NewTimer(time.Until(until))
+	defer timer.Stop()
+	select {
+	case <-exited:
+		return
+	case <-timer.C:
+	}
+
+	logger.Warnw("[parent]child not exited in time, terminate it: "+p.name, nil)
+	if err := cmd.Process.Signal(syscall.SIGTERM); err == nil {
+		timer.Reset(stopGrace)
+		select {
+		case <-exited:
+			return
+		case <-timer.C:
+		}
+	}
+
+	logger.Warnw("[parent]force to kill child: "+p.name, nil)
+	cmd.Process.Kill()
+	<-exited
 }
 
 func (p *SubProcCaller) Call(serviceMethod string, args any, reply any) error {
-	if (!debugEnabled && p.cmd == nil) || p.cli == nil {
+	cli, err := p.client()
+	if err != nil {
+		return err
+	}
+
+	return cli.Call(serviceMethod, args, reply)
+}
+
+// CallContext 可被ctx取消/超时的RPC调用
+//
+//	(超时返回ACTION_TIMEOUT, 取消返回ACTION_CANCELED)
+func (p *SubProcCaller) CallContext(ctx context.Context, serviceMethod string, args any, reply any) error {
+	cli, err := p.client()
+	if err != nil {
+		return err
+	}
+
+	err = callContext(ctx, cli, serviceMethod, args, reply)
+	if isTimeout(err) {
+		if kill := p.killOnTimeout.Load(); kill || p.closeOnTimeout.Load() {
+			p.teardown(cli, serviceMethod, kill)
+		}
+	}
+	return err
+}
+
+// CallTimeout 带超时的RPC调用
+func (p *SubProcCaller) CallTimeout(serviceMethod string, args any, reply any, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return p.CallContext(ctx, serviceMethod, args, reply)
+}
+
+// CallAsync 异步RPC调用, 结果通过callback通知
+//
+//	(callback在独立协程中执行, 可通过thread.AsyncWorker.Post切换到工作队列)
+func (p *SubProcCaller) CallAsync(serviceMethod string, args any, reply any, callback base.Callback) {
+	cli, err := p.client()
+	if err != nil {
+		callback.On(callResult(serviceMethod, err))
+		return
+	}
+
+	callAsync(cli, serviceMethod, args, reply, callback)
+}
+
+// SetCloseOnTimeout 设置调用超时后是否断开RPC连接
+//
+//	(断开后立即重新连接子进程, 超时调用的应答被丢弃; 子进程不受影响)
+func (p *SubProcCaller) SetCloseOnTimeout(close bool) *SubProcCaller {
+	p.closeOnTimeout.Store(close)
+	return p
+}
+
+// SetKillOnTimeout 设置调用超时后是否断开RPC连接并杀死子进程
+//
+//	(已设置重启策略时子进程将被重启)
+func (p *SubProcCaller) SetKillOnTimeout(kill bool) *SubProcCaller {
+	p.killOnTimeout.Store(kill)
+	return p
+}
+
+func (p *SubProcCaller) client() (*rpc.Client, error) {
+	p.mutex.Lock()
+	cli, restarting := p.cli, p.restarting
+	p.mutex.Unlock()
+
+	if restarting {
+		res := base.TRY_AGAIN_LATER.AppendMsg("child is restarting: " + p.name)
+		logger.Warnw("[parent]Call failed", res)
+		return nil, res
+	}
+	if cli == nil {
 		res := base.LOGICAL_ERROR.AppendMsg("create child first: " + p.name)
 		logger.Warnw("[parent]Call failed", res)
-		return res
+		return nil, res
+	}
+	return cli, nil
+}
+
+// teardown 调用超时后断开连接, kill为true时杀死子进程, 否则重新连接
+func (p *SubProcCaller) teardown(cli *rpc.Client, serviceMethod string, kill bool) {
+	p.mutex.Lock()
+	cmd := p.cmd
+	name, rpcAddr, token, codec := p.name, p.rpcAddr, p.token, p.codec
+	if p.cli != cli {
+		// 已被重建
+		p.mutex.Unlock()
+		return
 	}
+	p.mutex.Unlock()
 
-	return p.cli.Call(serviceMethod, args, reply)
+	cli.Close()
+	if kill {
+		logger.Warnw("[parent]call timeout, tear down child: "+name, nil, "method", serviceMethod)
+		if cmd != nil && cmd.Process != nil {
+			cmd.Process.Kill()
+		}
+		return
+	}
+
+	logger.Warnw("[parent]call timeout, reconnect child: "+name, nil, "method", serviceMethod)
+	client, err := dialRunner(name, rpcAddr, token, codec)
+	if err != nil {
+		logger.Warnw("[parent]reconnect child failed: "+name, err)
+		return
+	}
+	p.mutex.Lock()
+	if p.cli != cli {
+		p.mutex.Unlock()
+		client.Close()
+		return
+	}
+	p.cli = client
+	p.mutex.Unlock()
+}
+
+// dialRunner 连接子进程RPC服务并完成握手
+func dialRunner(name, rpcAddr, token string, codec Codec) (*rpc.Client, error) {
+	conn, err := dialAddress(rpcAddr)
+	if err != nil {
+		return nil, err
+	}
+	err = clientHandshake(conn, &handshakeReq{Token: token, Name: name, Codec: codec.String()})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newClient(codec, conn), nil
 }
 
 func (p *SubProcCaller) Ping() (error, bool /*alive*/) {
@@ -0,0 +1,448 @@
+package spr
+
+import (
+	"context"
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+type BalanceMode int
+
+const (
+	BalanceRoundRobin    BalanceMode = iota // 轮询
+	BalanceLeastInFlight                    // 最少进行中调用
+)
+
+// PoolMemberStats 进程池成员的统计信息
+type PoolMemberStats struct {
+	Name     string
+	Pid      int
+	Alive    bool
+	InFlight int64  // 进行中的调用数
+	Calls    uint64 // 累计调用数
+	Failures uint64 // 累计失败的调用数
+	Restarts uint64 // 累计重启次数
+}
+
+// PoolStats 进程池的统计信息
+type PoolStats struct {
+	Name     string
+	Size     int    // 目标成员数
+	Replaced uint64 // 累计被替换的成员数
+	Members  []PoolMemberStats
+}
+
+// SubProcPool 同构子进程池
+//
+//	(启动N个相同程序的子进程, 按负载均衡策略分发调用, 自动替换失效的成员)
+type SubProcPool struct {
+	mutex     sync.Mutex
+	name      string
+	program   string
+	typers    []RunnerTyper
+	options   *LaunchOptions
+	policy    *RestartPolicy
+	heartbeat *HeartbeatOptions
+	minVer    *MinRunnerVersion
+	transport Transport
+	codec     Codec
+	mode      BalanceMode
+	deadline  time.Duration
+
+	running  bool
+	size     int
+	members  []*poolMember
+	starting []*poolMember // 启动中的成员
+	nextId   int
+	retries  int         // 连续补充失败的次数
+	retry    *time.Timer // 等待中的补充重试
+	rrIndex  atomic.Uint64
+	replaced atomic.Uint64
+}
+
+type poolMember struct {
+	name     string
+	caller   *SubProcCaller
+	pid      atomic.Int64
+	alive    atomic.Bool
+	inFlight atomic.Int64
+	calls    atomic.Uint64
+	failures atomic.Uint64
+	restarts atomic.Uint64
+}
+
+func NewSubProcPool(name, program string, typers []RunnerTyper, options *LaunchOptions) *SubProcPool {
+	if options == nil {
+		options = &LaunchOptions{}
+	}
+	return &SubProcPool{
+		name:     name,
+		program:  program,
+		typers:   typers,
+		options:  options,
+		policy:   DefaultRestartPolicy(),
+		deadline: DefaultStopDeadline,
+	}
+}
+
+// SetBalanceMode 设置负载均衡策略(默认BalanceRoundRobin)
+func (p *SubProcPool) SetBalanceMode(mode BalanceMode) *SubProcPool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.mode = mode
+	return p
+}
+
+// SetRestartPolicy 设置成员的自动重启策略, 超过重启限制的成员将被替换
+//
+//	(nil表示成员异常退出后直接替换)
+func (p *SubProcPool) SetRestartPolicy(policy *RestartPolicy) *SubProcPool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.policy = policy
+	return p
+}
+
+// SetHeartbeat 设置成员的心跳检测
+func (p *SubProcPool) SetHeartbeat(options *HeartbeatOptions) *SubProcPool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.heartbeat = options
+	return p
+}
+
+// SetMinRunnerVersion 设置要求的成员最低版本
+func (p *SubProcPool) SetMinRunnerVersion(min *MinRunnerVersion) *SubProcPool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.minVer = min
+	return p
+}
+
+// SetTransport 设置成员RPC服务的传输方式
+func (p *SubProcPool) SetTransport(transport Transport) *SubProcPool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.transport = transport
+	return p
+}
+
+// SetCodec 设置成员RPC服务的编解码方式
+func (p *SubProcPool) SetCodec(codec Codec) *SubProcPool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.codec = codec
+	return p
+}
+
+// SetStopDeadline 设置终止成员时等待其进行中调用完成的期限(默认DefaultStopDeadline)
+func (p *SubProcPool) SetStopDeadline(deadline time.Duration) *SubProcPool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.deadline = deadline
+	return p
+}
+
+// Start 启动size个成员
+//
+//	(阻塞当前执行,直到所有成员启动完成; 任一成员启动失败时终止已启动的成员并返回错误)
+func (p *SubProcPool) Start(size int) base.Result {
+	p.mutex.Lock()
+	if p.running {
+		p.mutex.Unlock()
+		res := base.LOGICAL_ERROR.AppendMsg("has been started")
+		logger.Warnw("[pool]Start failed for "+p.name, res)
+		return res
+	}
+	p.running = true
+	p.size = size
+	p.mutex.Unlock()
+
+	res := p.fill()
+	if !res.IsOk() {
+		p.Stop()
+	}
+	return res
+}
+
+// Resize 调整成员数
+//
+//	(扩容时阻塞当前执行直到新成员启动完成, 启动失败时在后台重试; 缩容时优先终止空闲的成员)
+func (p *SubProcPool) Resize(size int) base.Result {
+	if size < 0 {
+		return base.INVALID_PARAM.AppendMsg("negative pool size")
+	}
+
+	p.mutex.Lock()
+	if !p.running {
+		p.mutex.Unlock()
+		return base.LOGICAL_ERROR.AppendMsg("start first: " + p.name)
+	}
+	p.size = size
+	deadline := p.deadline
+	var removed []*poolMember
+	for len(p.members) > size {
+		idx := 0
+		for i, m := range p.members {
+			if m.inFlight.Load() < p.members[idx].inFlight.Load() {
+				idx = i
+			}
+		}
+		removed = append(removed, p.members[idx])
+		p.members = append(p.members[:idx], p.members[idx+1:]...)
+	}
+	p.mutex.Unlock()
+
+	for _, m := range removed {
+		logger.Infow("[pool]shrink, terminate member: " + m.name)
+		go m.caller.TerminateRunnerSafely(deadline)
+	}
+
+	res := p.fill()
+	if !res.IsOk() {
+		p.retryFill()
+	}
+	return res
+}
+
+// Stop 终止所有成员
+func (p *SubProcPool) Stop() {
+	p.mutex.Lock()
+	p.running = false
+	p.size = 0
+	members := p.members
+	p.members = nil
+	p.retries = 0
+	if p.retry != nil {
+		p.retry.Stop()
+		p.retry = nil
+	}
+	deadline := p.deadline
+	p.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, m := range members {
+		wg.Add(1)
+		go func(m *poolMember) {
+			defer wg.Done()
+			m.caller.TerminateRunnerSafely(deadline)
+		}(m)
+	}
+	wg.Wait()
+}
+
+func (p *SubProcPool) Call(serviceMethod string, args any, reply any) error {
+	return p.CallContext(context.Background(), serviceMethod, args, reply)
+}
+
+// CallContext 选择一个成员进行可被ctx取消/超时的RPC调用
+func (p *SubProcPool) CallContext(ctx context.Context, serviceMethod string, args any, reply any) error {
+	m := p.pick()
+	if m == nil {
+		res := base.TRY_AGAIN_LATER.AppendMsg("has no alive member: " + p.name)
+		logger.Warnw("[pool]Call failed", res)
+		return res
+	}
+
+	m.inFlight.Inc()
+	defer m.inFlight.Dec()
+	m.calls.Inc()
+
+	err := m.caller.CallContext(ctx, serviceMethod, args, reply)
+	if err != nil {
+		m.failures.Inc()
+	}
+	return err
+}
+
+// Stats 进程池的统计信息
+func (p *SubProcPool) Stats() *PoolStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	stats := &PoolStats{Name: p.name, Size: p.size, Replaced: p.replaced.Load()}
+	for _, m := range p.members {
+		stats.Members = append(stats.Members, PoolMemberStats{
+			Name:     m.name,
+			Pid:      int(m.pid.Load()),
+			Alive:    m.alive.Load(),
+			InFlight: m.inFlight.Load(),
+			Calls:    m.calls.Load(),
+			Failures: m.failures.Load(),
+			Restarts: m.restarts.Load(),
+		})
+	}
+	return stats
+}
+
+//////////////////////////////////// private functions
+
+func (p *SubProcPool) pick() *poolMember {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	n := len(p.members)
+	if n == 0 {
+		return nil
+	}
+
+	// 从轮询位置开始查找, 使进行中调用数相同的成员轮流被选中
+	start := int(p.rrIndex.Inc() % uint64(n))
+	var best *poolMember
+	for i := 0; i < n; i++ {
+		m := p.members[(start+i)%n]
+		if !m.alive.Load() {
+			continue
+		}
+		if p.mode != BalanceLeastInFlight {
+			return m
+		}
+		if best == nil || m.inFlight.Load() < best.inFlight.Load() {
+			best = m
+		}
+	}
+	return best
+}
+
+// fill 补足成员数, 成员启动失败时返回错误
+func (p *SubProcPool) fill() base.Result {
+	for {
+		p.mutex.Lock()
+		if !p.running || len(p.members)+p.pending() >= p.size {
+			p.mutex.Unlock()
+			return base.SUCCESS
+		}
+		m := p.newMember()
+		p.mutex.Unlock()
+
+		res := m.caller.CreateAndConnectRunnerWithOptions(m.name, p.program, p.typers, p.options)
+
+		p.mutex.Lock()
+		p.removePending(m)
+		if !res.IsOk() {
+			p.mutex.Unlock()
+			logger.Warnw("[pool]start member failed: "+m.name, res)
+			return res
+		}
+		if !p.running || len(p.members) >= p.size {
+			deadline := p.deadline
+			p.mutex.Unlock()
+			go m.caller.TerminateRunnerSafely(deadline)
+			continue
+		}
+		p.members = append(p.members, m)
+		p.mutex.Unlock()
+	}
+}
+
+// refill 在后台补足成员数, 失败时按重启策略的等待时间重试
+func (p *SubProcPool) refill() {
+	if res := p.fill(); !res.IsOk() {
+		p.retryFill()
+		return
+	}
+
+	p.mutex.Lock()
+	p.retries = 0
+	p.mutex.Unlock()
+}
+
+// retryFill 稍后重试补充成员(已有等待中的重试时忽略)
+func (p *SubProcPool) retryFill() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.running || p.retry != nil {
+		return
+	}
+
+	policy := p.policy
+	if policy == nil {
+		policy = DefaultRestartPolicy()
+	}
+	p.retries++
+	backoff := policy.backoff(p.retries)
+	logger.Infow("[pool]refill later: "+p.name, "backoff", backoff, "retries", p.retries)
+
+	var timer *time.Timer
+	timer = time.AfterFunc(backoff, func() {
+		p.mutex.Lock()
+		if p.retry != timer {
+			p.mutex.Unlock()
+			return
+		}
+		p.retry = nil
+		p.mutex.Unlock()
+		p.refill()
+	})
+	p.retry = timer
+}
+
+// newMember 创建成员(调用时需持有锁)
+func (p *SubProcPool) newMember() *poolMember {
+	p.nextId++
+	m := &poolMember{name: p.name + "-" + strconv.Itoa(p.nextId), caller: &SubProcCaller{}}
+	policy := p.policy
+	m.caller.SetTransport(p.transport)
+	m.caller.SetCodec(p.codec)
+	m.caller.SetRestartPolicy(policy)
+	m.caller.SetHeartbeat(p.heartbeat)
+	m.caller.SetMinRunnerVersion(p.minVer)
+	m.caller.SetEventCallback(func(event *ChildEvent) {
+		switch event.Type {
+		case ChildStarted:
+			m.pid.Store(int64(event.Pid))
+			m.alive.Store(true)
+		case ChildExited:
+			m.alive.Store(false)
+			if !event.Expected && policy == nil {
+				p.replace(m)
+			}
+		case ChildRestarting:
+			m.restarts.Inc()
+		case ChildGaveUp:
+			p.replace(m)
+		}
+	})
+	p.starting = append(p.starting, m)
+	return m
+}
+
+func (p *SubProcPool) pending() int {
+	return len(p.starting)
+}
+
+func (p *SubProcPool) removePending(m *poolMember) {
+	for i, s := range p.starting {
+		if s == m {
+			p.starting = append(p.starting[:i], p.starting[i+1:]...)
+			return
+		}
+	}
+}
+
+// replace 移除失效的成员并补充新成员
+func (p *SubProcPool) replace(m *poolMember) {
+	p.mutex.Lock()
+	found := false
+	for i, s := range p.members {
+		if s == m {
+			p.members = append(p.members[:i], p.members[i+1:]...)
+			found = true
+			break
+		}
+	}
+	p.mutex.Unlock()
+	if !found {
+		return
+	}
+
+	logger.Warnw("[pool]replace dead member: "+m.name, nil)
+	p.replaced.Inc()
+	m.caller.TerminateRunnerFastly()
+	go p.refill()
+}
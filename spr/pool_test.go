@@ -0,0 +1,147 @@
+package spr
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func startPool(t *testing.T, size int, mode BalanceMode, policy *RestartPolicy) *SubProcPool {
+	pool := NewSubProcPool(t.Name(), os.Args[0], []RunnerTyper{&TestSvr{}},
+		&LaunchOptions{Env: []string{envTestRunner + "=1"}}).
+		SetBalanceMode(mode).SetRestartPolicy(policy)
+	if res := pool.Start(size); !res.IsOk() {
+		t.Fatal("start:", res)
+	}
+	t.Cleanup(pool.Stop)
+	return pool
+}
+
+func poolPid(t *testing.T, pool *SubProcPool) int {
+	var pid int
+	if err := pool.Call("ctl.Pid", 0, &pid); err != nil {
+		t.Fatal("Pid:", err)
+	}
+	return pid
+}
+
+func TestPoolRoundRobin(t *testing.T) {
+	pool := startPool(t, 3, BalanceRoundRobin, DefaultRestartPolicy())
+
+	counts := map[int]int{}
+	for i := 0; i < 9; i++ {
+		counts[poolPid(t, pool)]++
+	}
+	if len(counts) != 3 {
+		t.Fatal("members:", counts)
+	}
+	for pid, n := range counts {
+		if n != 3 {
+			t.Fatal("not balanced:", pid, n)
+		}
+	}
+}
+
+func TestPoolLeastInFlight(t *testing.T) {
+	pool := startPool(t, 2, BalanceLeastInFlight, DefaultRestartPolicy())
+
+	busy := make(chan int, 1)
+	go func() {
+		var pid int
+		pool.Call("ctl.Sleep", 300, &pid)
+		busy <- pid
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// 空闲的成员始终被选中
+	pids := map[int]bool{}
+	for i := 0; i < 4; i++ {
+		pids[poolPid(t, pool)] = true
+	}
+	busyPid := <-busy
+	if len(pids) != 1 || pids[busyPid] {
+		t.Fatal("picked busy member:", pids, busyPid)
+	}
+}
+
+func TestPoolReplace(t *testing.T) {
+	pool := startPool(t, 2, BalanceRoundRobin, nil)
+	before := map[int]bool{}
+	for _, m := range pool.Stats().Members {
+		before[m.Pid] = true
+	}
+
+	var reply int
+	pool.Call("ctl.Exit", 1, &reply)
+
+	var stats *PoolStats
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		stats = pool.Stats()
+		if stats.Replaced == 1 && len(stats.Members) == 2 && stats.Members[1].Alive {
+			break
+		}
+	}
+	if stats.Replaced != 1 || len(stats.Members) != 2 {
+		t.Fatal("not replaced:", stats)
+	}
+	replaced := 0
+	for _, m := range stats.Members {
+		if !before[m.Pid] {
+			replaced++
+		}
+	}
+	if replaced != 1 {
+		t.Fatal("members:", stats.Members)
+	}
+	for i := 0; i < 4; i++ {
+		poolPid(t, pool)
+	}
+}
+
+func TestPoolStartFailed(t *testing.T) {
+	pool := NewSubProcPool(t.Name(), "/nonexistent/program", nil, nil)
+	for i := 0; i < 2; i++ {
+		// 启动失败后回到未启动状态, 可再次启动
+		if res := pool.Start(2); res.IsOk() {
+			t.Fatal("start:", res)
+		}
+		pool.mutex.Lock()
+		running, retry, members := pool.running, pool.retry, len(pool.members)
+		pool.mutex.Unlock()
+		if running || retry != nil || members != 0 {
+			t.Fatal("not rolled back:", running, retry, members)
+		}
+	}
+}
+
+func TestPoolRefillBackoff(t *testing.T) {
+	pool := startPool(t, 1, BalanceRoundRobin,
+		&RestartPolicy{InitialBackoff: 20 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2})
+
+	pool.mutex.Lock()
+	pool.program = "/nonexistent/program"
+	pool.mutex.Unlock()
+	if res := pool.Resize(2); res.IsOk() {
+		t.Fatal("resize:", res)
+	}
+
+	// 20ms, 40ms, 80ms, 160ms...
+	time.Sleep(250 * time.Millisecond)
+	pool.mutex.Lock()
+	retries, retry := pool.retries, pool.retry
+	pool.mutex.Unlock()
+	if retries < 3 || retries > 5 || retry == nil {
+		t.Fatal("retries:", retries, retry)
+	}
+	if stats := pool.Stats(); len(stats.Members) != 1 {
+		t.Fatal("members:", stats.Members)
+	}
+
+	pool.Stop()
+	pool.mutex.Lock()
+	retry = pool.retry
+	pool.mutex.Unlock()
+	if retry != nil {
+		t.Fatal("retry not stopped")
+	}
+}
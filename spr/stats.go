@@ -0,0 +1,194 @@
+package spr
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	"runtime"
+	"time"
+)
+
+// ProcStats 子进程的资源占用
+type ProcStats struct {
+	Pid        int
+	RSS        uint64        // 常驻内存(字节)
+	PeakRSS    uint64        // 常驻内存峰值(字节)
+	VmSize     uint64        // 虚拟内存(字节)
+	UserTime   time.Duration // 用户态CPU时间
+	SysTime    time.Duration // 内核态CPU时间
+	CPUPercent float64       // 两次采样之间的CPU占用率(100表示占满一个核, 仅采样时有效)
+	Threads    int           // 线程数
+	FDs        int           // 打开的文件描述符数
+}
+
+// CPUTime 累计CPU时间
+func (s *ProcStats) CPUTime() time.Duration {
+	return s.UserTime + s.SysTime
+}
+
+// ResourceMonitor 子进程资源监控参数
+//
+//	(阈值为0表示不检查该项)
+type ResourceMonitor struct {
+	Interval      time.Duration // 采样间隔
+	MaxRSS        uint64        // 常驻内存上限(字节)
+	MaxCPUPercent float64       // CPU占用率上限
+	MaxThreads    int           // 线程数上限
+	MaxFDs        int           // 文件描述符数上限
+	Sustain       int           // 连续多少次采样超过阈值才处理(<=0表示1)
+	Restart       bool          // 超过阈值时是否杀死子进程(配合SetRestartPolicy自动重启)
+	OnSample      func(stats *ProcStats)
+}
+
+// RuntimeStats 子进程的Go运行时状态
+type RuntimeStats struct {
+	Goroutines  int           `json:"goroutines"`
+	HeapAlloc   uint64        `json:"heapAlloc"`   // 堆上已分配的字节数
+	HeapInuse   uint64        `json:"heapInuse"`   // 堆上使用中的span字节数
+	HeapObjects uint64        `json:"heapObjects"` // 堆上的对象数
+	Sys         uint64        `json:"sys"`         // 从系统获取的总字节数
+	NumGC       uint32        `json:"numGC"`
+	PauseTotal  time.Duration `json:"pauseTotal"`
+}
+
+// SetResourceMonitor 设置子进程资源监控(nil表示不监控)
+//
+//	(需在创建子进程前设置; 超过阈值时触发ChildOverLimit事件)
+func (p *SubProcCaller) SetResourceMonitor(options *ResourceMonitor) *SubProcCaller {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.monitor = nil
+	if options != nil {
+		// 复制一份再填充默认值, 不修改调用者的参数
+		opts := *options
+		if opts.Interval <= 0 {
+			opts.Interval = 5 * time.Second
+		}
+		p.monitor = &opts
+	}
+	return p
+}
+
+// Stats 读取子进程当前的资源占用(仅Linux)
+func (p *SubProcCaller) Stats() (*ProcStats, base.Result) {
+	p.mutex.Lock()
+	cmd := p.cmd
+	p.mutex.Unlock()
+
+	if cmd == nil || cmd.Process == nil {
+		return nil, base.LOGICAL_ERROR.AppendMsg("child is not running: " + p.name)
+	}
+	stats, err := readProcStats(cmd.Process.Pid)
+	if err != nil {
+		if res, ok := err.(base.Result); ok {
+			return nil, res
+		}
+		return nil, base.REMOTE_SYSTEM_ERROR.AppendErr("read child stats failed", err)
+	}
+	return stats, base.SUCCESS
+}
+
+// RuntimeStats 查询子进程的Go运行时状态
+func (p *SubProcCaller) RuntimeStats() (*RuntimeStats, base.Result) {
+	var data []byte
+	if err := p.Call(RuntimeStatsMethod, 0, &data); err != nil {
+		return nil, callResult(RuntimeStatsMethod, err)
+	}
+
+	stats := &RuntimeStats{}
+	if err := json.Unmarshal(data, stats); err != nil {
+		return nil, base.REMOTE_SYSTEM_ERROR.AppendErr("decode runtime stats failed", err)
+	}
+	return stats, base.SUCCESS
+}
+
+//////////////////////////////////// private functions
+
+func (p *SubProcCaller) monitorLoop(options *ResourceMonitor, quit chan struct{}) {
+	ticker := time.NewTicker(options.Interval)
+	defer ticker.Stop()
+
+	var last *ProcStats
+	var lastTime time.Time
+	exceeds := 0
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+
+		p.mutex.Lock()
+		cmd, onEvent := p.cmd, p.onEvent
+		p.mutex.Unlock()
+		if cmd == nil || cmd.Process == nil {
+			// 子进程重启中
+			last, exceeds = nil, 0
+			continue
+		}
+
+		stats, err := readProcStats(cmd.Process.Pid)
+		if err != nil {
+			logger.Debugw("[parent]read child stats failed: "+p.name, "error", err)
+			last, exceeds = nil, 0
+			continue
+		}
+		now := time.Now()
+		if last != nil && last.Pid == stats.Pid {
+			stats.CPUPercent = float64(stats.CPUTime()-last.CPUTime()) * 100 / float64(now.Sub(lastTime))
+		}
+		last, lastTime = stats, now
+		if options.OnSample != nil {
+			options.OnSample(stats)
+		}
+
+		reason := options.exceeded(stats)
+		if reason == "" {
+			exceeds = 0
+			continue
+		}
+		exceeds++
+		if exceeds < max(options.Sustain, 1) {
+			continue
+		}
+
+		exceeds = 0
+		res := base.TRY_AGAIN_LATER.AppendMsg("child is over limit: " + p.name + ", " + reason)
+		logger.Warnw("[parent]child over limit", res, "pid", stats.Pid, "restart", options.Restart)
+		onEvent.On(&ChildEvent{Type: ChildOverLimit, Name: p.name, Pid: stats.Pid, Result: res, Stats: stats})
+		if options.Restart {
+			cmd.Process.Kill()
+		}
+	}
+}
+
+// exceeded 超过阈值的项, 未超过时返回空
+func (m *ResourceMonitor) exceeded(stats *ProcStats) string {
+	switch {
+	case m.MaxRSS > 0 && stats.RSS > m.MaxRSS:
+		return fmt.Sprintf("rss %d > %d", stats.RSS, m.MaxRSS)
+	case m.MaxCPUPercent > 0 && stats.CPUPercent > m.MaxCPUPercent:
+		return fmt.Sprintf("cpu %.1f%% > %.1f%%", stats.CPUPercent, m.MaxCPUPercent)
+	case m.MaxThreads > 0 && stats.Threads > m.MaxThreads:
+		return fmt.Sprintf("threads %d > %d", stats.Threads, m.MaxThreads)
+	case m.MaxFDs > 0 && stats.FDs > m.MaxFDs:
+		return fmt.Sprintf("fds %d > %d", stats.FDs, m.MaxFDs)
+	default:
+		return ""
+	}
+}
+
+func currentRuntimeStats() *RuntimeStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return &RuntimeStats{
+		Goroutines:  runtime.NumGoroutine(),
+		HeapAlloc:   mem.HeapAlloc,
+		HeapInuse:   mem.HeapInuse,
+		HeapObjects: mem.HeapObjects,
+		Sys:         mem.Sys,
+		NumGC:       mem.NumGC,
+		PauseTotal:  time.Duration(mem.PauseTotalNs),
+	}
+}
@@ -0,0 +1,81 @@
+//go:build linux
+
+package spr
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/patstar123/go-base"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// /proc/<pid>/stat中CPU时间的单位(USER_HZ, Linux上固定为100)
+const clockTicks = 100
+
+func readProcStats(pid int) (*ProcStats, error) {
+	return readProcDir(pid, "/proc/"+strconv.Itoa(pid))
+}
+
+// readProcDir 从进程的proc目录(/proc/<pid>)中读取资源占用
+func readProcDir(pid int, dir string) (*ProcStats, error) {
+	stats := &ProcStats{Pid: pid}
+
+	// stat: 进程名可能包含空格和括号, 从最后一个')'之后开始按字段解析
+	data, err := os.ReadFile(dir + "/stat")
+	if err != nil {
+		return nil, base.INTERNAL_ERROR.AppendErr("read proc stat failed", err)
+	}
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return nil, base.INTERNAL_ERROR.AppendMsg("invalid proc stat")
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 22 {
+		return nil, base.INTERNAL_ERROR.AppendMsg("invalid proc stat")
+	}
+	// fields[0]为第3个字段(state)
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	threads, _ := strconv.Atoi(fields[17])
+	vsize, _ := strconv.ParseUint(fields[20], 10, 64)
+	stats.UserTime = time.Duration(utime) * time.Second / clockTicks
+	stats.SysTime = time.Duration(stime) * time.Second / clockTicks
+	stats.Threads = threads
+	stats.VmSize = vsize
+
+	// status: 内存以kB为单位
+	file, err := os.Open(dir + "/status")
+	if err != nil {
+		return nil, base.INTERNAL_ERROR.AppendErr("read proc status failed", err)
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "VmRSS":
+			stats.RSS = parseKB(value)
+		case "VmHWM":
+			stats.PeakRSS = parseKB(value)
+		}
+	}
+	file.Close()
+
+	entries, err := os.ReadDir(dir + "/fd")
+	if err != nil {
+		return nil, base.INTERNAL_ERROR.AppendErr("read proc fd failed", err)
+	}
+	stats.FDs = len(entries)
+	return stats, nil
+}
+
+func parseKB(value string) uint64 {
+	value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "kB"))
+	kb, _ := strconv.ParseUint(value, 10, 64)
+	return kb * 1024
+}
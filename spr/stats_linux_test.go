@@ -0,0 +1,99 @@
+//go:build linux
+
+package spr
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeProcFixture 按/proc/<pid>的格式生成测试目录
+func writeProcFixture(t *testing.T, stat, status string, fds int) string {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "status"), []byte(status), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Mkdir(filepath.Join(dir, "fd"), 0755)
+	for i := 0; i < fds; i++ {
+		os.WriteFile(filepath.Join(dir, "fd", strconv.Itoa(i)), nil, 0644)
+	}
+	return dir
+}
+
+func TestReadProcDir(t *testing.T) {
+	// 进程名包含空格和括号; utime=250, stime=50, threads=7, vsize=123456789
+	stat := "4242 (my (app) x) S 1 4242 4242 0 -1 4194560 100 0 0 0 250 50 0 0 20 0 7 0 12345 123456789 2000\n"
+	status := strings.Join([]string{"Name:\tmy (app) x", "VmPeak:\t  200000 kB", "VmHWM:\t    9000 kB",
+		"VmRSS:\t    8000 kB", "Threads:\t7"}, "\n")
+	dir := writeProcFixture(t, stat, status, 5)
+
+	stats, err := readProcDir(4242, dir)
+	if err != nil {
+		t.Fatal("read:", err)
+	}
+	want := ProcStats{Pid: 4242, RSS: 8000 * 1024, PeakRSS: 9000 * 1024, VmSize: 123456789,
+		UserTime: 2500 * time.Millisecond, SysTime: 500 * time.Millisecond, Threads: 7, FDs: 5}
+	if *stats != want {
+		t.Fatal("stats:", *stats)
+	}
+
+	for name, stat := range map[string]string{"no name": "4242 S 1", "truncated": "4242 (app) S 1 2 3"} {
+		if _, err = readProcDir(4242, writeProcFixture(t, stat, status, 0)); err == nil {
+			t.Fatal(name, "accepted")
+		}
+	}
+	if _, err = readProcDir(4242, filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("missing dir accepted")
+	}
+}
+
+func TestResourceMonitorOverLimit(t *testing.T) {
+	events := make(chan *ChildEvent, 16)
+	samples := make(chan *ProcStats, 16)
+	caller := (&SubProcCaller{}).SetEventCallback(func(event *ChildEvent) { events <- event }).
+		SetResourceMonitor(&ResourceMonitor{Interval: 20 * time.Millisecond, MaxThreads: 1, Sustain: 2,
+			Restart: true, OnSample: func(stats *ProcStats) {
+				select {
+				case samples <- stats:
+				default:
+				}
+			}})
+	startChild(t, caller, "child", nil)
+
+	// 连续2次采样超过阈值后上报并杀死子进程
+	event := waitEvent(t, events, ChildOverLimit)
+	if event.Stats == nil || event.Stats.Threads <= 1 || !strings.Contains(event.Result.Message(), "threads") {
+		t.Fatal("over limit:", event, event.Stats)
+	}
+	if len(samples) < 2 {
+		t.Fatal("samples:", len(samples))
+	}
+	if event = waitEvent(t, events, ChildExited); event.Expected || event.Signal != "killed" {
+		t.Fatal("exit event:", event)
+	}
+}
+
+func TestResourceMonitorExceeded(t *testing.T) {
+	monitor := &ResourceMonitor{MaxRSS: 100, MaxCPUPercent: 50, MaxThreads: 4, MaxFDs: 10}
+	for reason, stats := range map[string]ProcStats{
+		"":        {RSS: 100, CPUPercent: 50, Threads: 4, FDs: 10},
+		"rss":     {RSS: 101},
+		"cpu":     {CPUPercent: 50.5},
+		"threads": {Threads: 5},
+		"fds":     {FDs: 11},
+	} {
+		if got := monitor.exceeded(&stats); !strings.HasPrefix(got, reason) || (reason == "") != (got == "") {
+			t.Fatal(reason, "got:", got)
+		}
+	}
+	if got := (&ResourceMonitor{}).exceeded(&ProcStats{RSS: 1 << 40}); got != "" {
+		t.Fatal("no limit:", got)
+	}
+}
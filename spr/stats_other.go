@@ -0,0 +1,9 @@
+//go:build !linux
+
+package spr
+
+import "github.com/patstar123/go-base"
+
+func readProcStats(pid int) (*ProcStats, error) {
+	return nil, base.ACTION_UNSUPPORTED.AppendMsg("proc stats is only supported on linux")
+}
@@ -0,0 +1,69 @@
+package spr
+
+import (
+	"github.com/patstar123/go-base"
+	"testing"
+	"time"
+)
+
+func TestStopDrain(t *testing.T) {
+	cases := []struct {
+		name     string
+		sleep    int
+		deadline time.Duration
+		drained  bool
+	}{
+		{"in time", 100, time.Second, true},
+		{"timeout", 1000, 50 * time.Millisecond, false},
+	}
+	for _, c := range cases {
+		fake := NewFakeRunner("fake")
+		hooked := make(chan bool, 1)
+		fake.Runner().SetShutdownHook(func(drained bool) { hooked <- drained })
+		if res := fake.Start(map[string]RpcSvr{"ctl": &TestCtl{}}, nil); !res.IsOk() {
+			t.Fatal(c.name, "start:", res)
+		}
+		caller := &SubProcCaller{}
+		if res := fake.Attach(caller, nil); !res.IsOk() {
+			t.Fatal(c.name, "attach:", res)
+		}
+
+		var reply int
+		called := make(chan base.Result, 1)
+		caller.CallAsync("ctl.Sleep", c.sleep, &reply, func(res base.Result) { called <- res })
+		time.Sleep(20 * time.Millisecond)
+		caller.TerminateRunnerSafely(c.deadline)
+
+		if drained := <-hooked; drained != c.drained {
+			t.Fatal(c.name, "drained:", drained)
+		}
+		// 期限内完成的调用正常应答
+		if res := <-called; res.IsOk() != c.drained {
+			t.Fatal(c.name, "in-flight call:", res, reply)
+		}
+		if res := fake.Wait(); !res.IsOk() {
+			t.Fatal(c.name, "exit:", res)
+		}
+	}
+}
+
+func TestStopEscalation(t *testing.T) {
+	events := make(chan *ChildEvent, 16)
+	caller := (&SubProcCaller{}).SetEventCallback(func(event *ChildEvent) { events <- event })
+	startChild(t, caller, "child", nil)
+
+	var reply int
+	if err := caller.Call("ctl.IgnoreStop", 0, &reply); err != nil {
+		t.Fatal("ignore stop:", err)
+	}
+
+	// 超过期限依次发送SIGTERM(被忽略)和SIGKILL
+	start := time.Now()
+	caller.TerminateRunnerSafely(50 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed < 2*stopGrace {
+		t.Fatal("killed too early:", elapsed)
+	}
+	if event := waitEvent(t, events, ChildExited); event.Signal != "killed" {
+		t.Fatal("exit event:", event)
+	}
+}
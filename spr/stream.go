@@ -0,0 +1,293 @@
+package spr
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	"io"
+	"net"
+	"sync"
+
+	"go.uber.org/atomic"
+)
+
+// Stream 父子进程间的命名双向流
+//
+//	. 每个流独占一条连接, 数据按包传输, 每次Write为一个包
+//	. 读取端基于base.RecyclableChan, 可按流(Read)或按包(ReadPacket/SetReadingAsPkt)读取
+//	. 基于包数的流量控制: 对端未读取的包数达到其接收窗口时, Write阻塞
+//	. 不支持半关闭: Close同时关闭两个方向; 对端关闭后本端Write返回ErrStreamClosed, 已收到的数据仍可读完, 之后返回io.EOF
+type Stream struct {
+	name   string
+	conn   net.Conn
+	window int
+	ch     *base.RecyclableChan
+
+	wmutex sync.Mutex // 串行写帧
+	writer *bufio.Writer
+
+	cmutex  sync.Mutex
+	cond    *sync.Cond
+	credit  int  // 可发送的包数
+	closing bool // 本端已关闭或连接已断开
+
+	delivered atomic.Int64 // 已放入接收通道的包数
+	granted   atomic.Int64 // 已归还给对端的包数
+	closed    atomic.Bool
+	remoteEOF atomic.Bool
+}
+
+// StreamHandler 子进程处理父进程打开的流
+type StreamHandler func(stream *Stream)
+
+const (
+	DefaultStreamWindow = 64              // 默认接收窗口(包数)
+	StreamMaxPacket     = 4 * 1024 * 1024 // 单个包的最大字节数
+	streamFrameHeader   = 5               // 帧头: 1字节类型 + 4字节大端长度
+)
+
+// 帧类型
+const (
+	frameData   byte = 1 // 数据包
+	frameCredit byte = 2 // 归还发送额度, 内容为4字节大端包数
+	frameClose  byte = 3 // 对端关闭
+)
+
+var ErrStreamClosed = errors.New("stream closed")
+
+// OpenStream 打开与子进程间的命名流, window为本端的接收窗口(<=0表示DefaultStreamWindow)
+//
+//	(子进程需通过SubProcRunner.SetStreamHandler接受流)
+func (p *SubProcCaller) OpenStream(name string, window int) (*Stream, base.Result) {
+	p.mutex.Lock()
+	cli, rpcAddr, token, codec := p.cli, p.rpcAddr, p.token, p.codec
+	p.mutex.Unlock()
+
+	if cli == nil {
+		res := base.LOGICAL_ERROR.AppendMsg("create child first: " + p.name)
+		logger.Warnw("[parent]OpenStream failed", res)
+		return nil, res
+	}
+	if name == "" {
+		return nil, base.INVALID_PARAM.AppendMsg("empty stream name")
+	}
+
+	conn, err := dialAddress(rpcAddr)
+	if err != nil {
+		res := base.INTERNAL_ERROR.AppendErr("connect child process failed", err)
+		logger.Warnw("[parent]OpenStream failed for "+p.name, res, "stream", name)
+		return nil, res
+	}
+	err = clientHandshake(conn, &handshakeReq{Token: token, Name: p.name, Codec: codec.String(), Stream: name})
+	if err != nil {
+		conn.Close()
+		res := base.ACTION_ILLEGAL.AppendErr("stream handshake failed", err)
+		logger.Warnw("[parent]OpenStream failed for "+p.name, res, "stream", name)
+		return nil, res
+	}
+
+	logger.Infow("[parent]stream opened", "child", p.name, "stream", name)
+	return newStream(name, conn, window), base.SUCCESS
+}
+
+// SetStreamHandler 设置父进程打开流时的处理函数(在新的协程中调用), window为本端的接收窗口
+//
+//	(需在Run之前设置, 未设置时拒绝父进程打开流)
+func (c *SubProcRunner) SetStreamHandler(window int, handler StreamHandler) *SubProcRunner {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.streamWindow = window
+	c.onStream = handler
+	return c
+}
+
+// Name 流名称
+func (s *Stream) Name() string {
+	return s.name
+}
+
+// SetReadingAsPkt 设置Read是否按包读取(缓冲区不足时返回io.ErrShortBuffer)
+func (s *Stream) SetReadingAsPkt(asPkt bool) *Stream {
+	s.ch.SetReadingAsPkt(asPkt)
+	return s
+}
+
+func (s *Stream) Read(b []byte) (int, error) {
+	if s.closed.Load() {
+		return 0, io.EOF
+	}
+	n, err := s.ch.Read(b)
+	s.grant()
+	return n, err
+}
+
+// ReadPacket 读取一个完整的包
+func (s *Stream) ReadPacket() ([]byte, error) {
+	if s.closed.Load() {
+		return nil, io.EOF
+	}
+	pkt, err := s.ch.ReadPacket()
+	s.grant()
+	return pkt, err
+}
+
+// Write 发送一个包, 对端接收窗口已满时阻塞
+func (s *Stream) Write(data []byte) (int, error) {
+	if len(data) > StreamMaxPacket {
+		return 0, errors.New("stream packet too large")
+	}
+
+	s.cmutex.Lock()
+	for s.credit <= 0 && !s.closing {
+		s.cond.Wait()
+	}
+	if s.closing {
+		s.cmutex.Unlock()
+		return 0, ErrStreamClosed
+	}
+	s.credit--
+	s.cmutex.Unlock()
+
+	if err := s.writeFrame(frameData, data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Close 关闭流(两个方向), 未读取的数据被丢弃
+func (s *Stream) Close() error {
+	if !s.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	if !s.remoteEOF.Load() {
+		s.writeFrame(frameClose, nil)
+	}
+	s.shutdown()
+	return s.conn.Close()
+}
+
+//////////////////////////////////// private functions
+
+func newStream(name string, conn net.Conn, window int) *Stream {
+	if window <= 0 {
+		window = DefaultStreamWindow
+	}
+	s := &Stream{
+		name:   name,
+		conn:   conn,
+		window: window,
+		ch:     base.NewRecyclableChan("stream:"+name, uint32(window), nil),
+		writer: bufio.NewWriter(conn),
+	}
+	s.cond = sync.NewCond(&s.cmutex)
+
+	// 告知对端本端的接收窗口
+	s.sendCredit(window)
+	go s.readLoop()
+	return s
+}
+
+// acceptStream 子进程接受父进程打开的流
+func (c *SubProcRunner) acceptStream(name string, conn net.Conn) {
+	c.mutex.Lock()
+	handler, window := c.onStream, c.streamWindow
+	c.mutex.Unlock()
+
+	logger.Infow("[child]stream accepted", "stream", name)
+	handler(newStream(name, conn, window))
+}
+
+func (s *Stream) readLoop() {
+	reader := bufio.NewReader(s.conn)
+	var header [streamFrameHeader]byte
+	for {
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			break
+		}
+		size := binary.BigEndian.Uint32(header[1:])
+		if size > StreamMaxPacket {
+			logger.Warnw("[stream]frame too large", nil, "stream", s.name, "size", size)
+			break
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			break
+		}
+
+		switch header[0] {
+		case frameData:
+			if _, err := s.ch.Write(data); err != nil {
+				// 对端未遵守流量控制
+				logger.Warnw("[stream]receive window overflow", err, "stream", s.name)
+				s.Close()
+			} else {
+				s.delivered.Inc()
+			}
+		case frameCredit:
+			if len(data) == 4 {
+				s.cmutex.Lock()
+				s.credit += int(binary.BigEndian.Uint32(data))
+				s.cmutex.Unlock()
+				s.cond.Broadcast()
+			}
+		case frameClose:
+			s.remoteEOF.Store(true)
+		}
+		if s.remoteEOF.Load() || s.closed.Load() {
+			break
+		}
+	}
+
+	// 对端关闭后不再发送; 接收通道中剩余的数据读完后返回io.EOF
+	s.shutdown()
+	s.ch.CloseWrite()
+	s.conn.Close()
+}
+
+// shutdown 唤醒并终止阻塞中的Write
+func (s *Stream) shutdown() {
+	s.cmutex.Lock()
+	s.closing = true
+	s.cmutex.Unlock()
+	s.cond.Broadcast()
+}
+
+// grant 本端读取后向对端归还发送额度
+//
+//	(累计达到窗口的1/4时才发送, 减少额度帧)
+func (s *Stream) grant() {
+	consumed := s.delivered.Load() - int64(s.ch.Len())
+	granted := s.granted.Load()
+	count := consumed - granted
+	if count <= 0 || count < int64(max(s.window/4, 1)) {
+		return
+	}
+	if s.granted.CompareAndSwap(granted, consumed) {
+		s.sendCredit(int(count))
+	}
+}
+
+func (s *Stream) sendCredit(count int) {
+	var data [4]byte
+	binary.BigEndian.PutUint32(data[:], uint32(count))
+	s.writeFrame(frameCredit, data[:])
+}
+
+func (s *Stream) writeFrame(typ byte, data []byte) error {
+	s.wmutex.Lock()
+	defer s.wmutex.Unlock()
+
+	var header [streamFrameHeader]byte
+	header[0] = typ
+	binary.BigEndian.PutUint32(header[1:], uint32(len(data)))
+	if _, err := s.writer.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := s.writer.Write(data); err != nil {
+		return err
+	}
+	return s.writer.Flush()
+}
@@ -0,0 +1,216 @@
+package spr
+
+import (
+	"io"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// startStreamFake 启动接受流的模拟子进程, 子进程端的流通过返回的通道获取
+func startStreamFake(t *testing.T, window int) (*SubProcCaller, chan *Stream) {
+	streams := make(chan *Stream, 1)
+	fake := NewFakeRunner("fake")
+	fake.Runner().SetStreamHandler(window, func(stream *Stream) { streams <- stream })
+	if res := fake.Start(map[string]RpcSvr{"svr": &TestSvr{}}, nil); !res.IsOk() {
+		t.Fatal("start:", res)
+	}
+	caller := &SubProcCaller{}
+	if res := fake.Attach(caller, nil); !res.IsOk() {
+		t.Fatal("attach:", res)
+	}
+	t.Cleanup(func() {
+		caller.TerminateRunnerFastly()
+		fake.Stop()
+	})
+	return caller, streams
+}
+
+func openStream(t *testing.T, caller *SubProcCaller, streams chan *Stream, window int) (*Stream, *Stream) {
+	parent, res := caller.OpenStream("s", window)
+	if !res.IsOk() {
+		t.Fatal("open:", res)
+	}
+	t.Cleanup(func() { parent.Close() })
+	select {
+	case child := <-streams:
+		t.Cleanup(func() { child.Close() })
+		return parent, child
+	case <-time.After(time.Second):
+		t.Fatal("stream not accepted")
+		return nil, nil
+	}
+}
+
+func TestStreamOrder(t *testing.T) {
+	caller, streams := startStreamFake(t, 8)
+	parent, child := openStream(t, caller, streams, 4)
+
+	// 两个方向同时发送, 数量远超接收窗口
+	const count = 500
+	errs := make(chan error, 2)
+	send := func(s *Stream) {
+		for i := 0; i < count; i++ {
+			if _, err := s.Write([]byte(strconv.Itoa(i))); err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- nil
+	}
+	go send(parent)
+	go send(child)
+
+	for _, s := range []*Stream{child, parent} {
+		for i := 0; i < count; i++ {
+			pkt, err := s.ReadPacket()
+			if err != nil || string(pkt) != strconv.Itoa(i) {
+				t.Fatal("read:", i, string(pkt), err)
+			}
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal("write:", err)
+		}
+	}
+}
+
+func TestStreamWindow(t *testing.T) {
+	caller, streams := startStreamFake(t, 4)
+	parent, child := openStream(t, caller, streams, 0)
+
+	for i := 0; i < 4; i++ {
+		parent.Write([]byte{byte(i)})
+	}
+	written := make(chan error, 1)
+	go func() {
+		_, err := parent.Write([]byte{4})
+		written <- err
+	}()
+	select {
+	case <-written:
+		t.Fatal("write not blocked when peer window is full")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// 读取后归还额度
+	if pkt, err := child.ReadPacket(); err != nil || pkt[0] != 0 {
+		t.Fatal("read:", pkt, err)
+	}
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal("write:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("write still blocked after peer read")
+	}
+}
+
+func TestStreamClose(t *testing.T) {
+	caller, streams := startStreamFake(t, 4)
+
+	for _, closeByChild := range []bool{false, true} {
+		parent, child := openStream(t, caller, streams, 4)
+		closer, peer := parent, child
+		if closeByChild {
+			closer, peer = child, parent
+		}
+
+		// 关闭前发送的数据仍可读取
+		closer.Write([]byte("last"))
+		closer.Close()
+		if pkt, err := peer.ReadPacket(); err != nil || string(pkt) != "last" {
+			t.Fatal("read before close:", closeByChild, string(pkt), err)
+		}
+		if _, err := peer.ReadPacket(); err != io.EOF {
+			t.Fatal("read after close:", closeByChild, err)
+		}
+
+		// 对端关闭后写入失败, 阻塞中的写入被唤醒
+		deadline := time.Now().Add(time.Second)
+		var err error
+		for err == nil && time.Now().Before(deadline) {
+			_, err = peer.Write([]byte("x"))
+		}
+		if err == nil {
+			t.Fatal("write after close:", closeByChild)
+		}
+		if _, err = closer.Write([]byte("x")); err != ErrStreamClosed {
+			t.Fatal("write on closed:", closeByChild, err)
+		}
+	}
+}
+
+func TestStreamReadingAsPkt(t *testing.T) {
+	caller, streams := startStreamFake(t, 4)
+	parent, child := openStream(t, caller, streams, 4)
+
+	parent.Write([]byte("hello"))
+	parent.Write([]byte("world!"))
+
+	child.SetReadingAsPkt(true)
+	buf := make([]byte, 16)
+	if n, err := child.Read(buf); err != nil || string(buf[:n]) != "hello" {
+		t.Fatal("read packet:", string(buf[:n]), err)
+	}
+	// 缓冲区不足时保留该包
+	if _, err := child.Read(buf[:3]); err != io.ErrShortBuffer {
+		t.Fatal("short buffer:", err)
+	}
+	if n, err := child.Read(buf); err != nil || string(buf[:n]) != "world!" {
+		t.Fatal("read packet:", string(buf[:n]), err)
+	}
+
+	// 按流读取时跨包拼接
+	parent.Write([]byte("ab"))
+	parent.Write([]byte("cd"))
+	child.SetReadingAsPkt(false)
+	var data []byte
+	for len(data) < 4 {
+		n, err := child.Read(buf[:3])
+		if err != nil {
+			t.Fatal("read stream:", err)
+		}
+		data = append(data, buf[:n]...)
+	}
+	if string(data) != "abcd" {
+		t.Fatal("read stream:", string(data))
+	}
+}
+
+func TestStreamReadAfterRemoteClose(t *testing.T) {
+	caller, streams := startStreamFake(t, 4)
+	parent, child := openStream(t, caller, streams, 4)
+
+	parent.Write([]byte("hello"))
+	parent.Write([]byte("world"))
+	parent.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	// 对端关闭后剩余数据(包括部分读取的包)仍可读完
+	buf := make([]byte, 3)
+	var data []byte
+	for {
+		n, err := child.Read(buf)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal("read:", err)
+		}
+		data = append(data, buf[:n]...)
+	}
+	if string(data) != "helloworld" {
+		t.Fatal("data:", string(data))
+	}
+
+	// 本端关闭后未读取的数据被丢弃
+	parent, child = openStream(t, caller, streams, 4)
+	parent.Write([]byte("dropped"))
+	time.Sleep(50 * time.Millisecond)
+	child.Close()
+	if _, err := child.ReadPacket(); err != io.EOF {
+		t.Fatal("read after local close:", err)
+	}
+}
@@ -0,0 +1,219 @@
+package spr
+
+import (
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// RestartPolicy 子进程自动重启策略
+type RestartPolicy struct {
+	InitialBackoff time.Duration // 首次重启前的等待时间
+	MaxBackoff     time.Duration // 重启等待时间上限
+	Multiplier     float64       // 等待时间的增长倍数
+	MaxRestarts    int           // 统计窗口内允许的最大重启次数(<=0表示不限制)
+	Window         time.Duration // 重启次数的统计窗口
+}
+
+func DefaultRestartPolicy() *RestartPolicy {
+	return &RestartPolicy{
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+		MaxRestarts:    5,
+		Window:         time.Minute,
+	}
+}
+
+func (r *RestartPolicy) backoff(restarts int) time.Duration {
+	backoff := r.InitialBackoff
+	for i := 1; i < restarts; i++ {
+		if r.Multiplier > 1 {
+			backoff = time.Duration(float64(backoff) * r.Multiplier)
+		}
+		if r.MaxBackoff > 0 && backoff >= r.MaxBackoff {
+			return r.MaxBackoff
+		}
+	}
+	if r.MaxBackoff > 0 && backoff > r.MaxBackoff {
+		backoff = r.MaxBackoff
+	}
+	return backoff
+}
+
+type ChildEventType int
+
+const (
+	ChildStarted      ChildEventType = iota // 子进程已启动并连接
+	ChildExited                             // 子进程已退出
+	ChildRestarting                         // 子进程即将重启
+	ChildGaveUp                             // 超过重启限制,放弃重启
+	ChildUnresponsive                       // 子进程心跳超时,已被杀死
+	ChildOverLimit                          // 子进程资源占用超过阈值
+)
+
+func (t ChildEventType) String() string {
+	switch t {
+	case ChildStarted:
+		return "started"
+	case ChildExited:
+		return "exited"
+	case ChildRestarting:
+		return "restarting"
+	case ChildGaveUp:
+		return "gaveUp"
+	case ChildUnresponsive:
+		return "unresponsive"
+	case ChildOverLimit:
+		return "overLimit"
+	default:
+		return "unknown"
+	}
+}
+
+// ChildEvent 子进程生命周期事件
+type ChildEvent struct {
+	Type     ChildEventType
+	Name     string
+	Pid      int
+	ExitCode int           // ChildExited: 退出码(被信号终止时为-1)
+	Signal   string        // ChildExited: 终止子进程的信号
+	Expected bool          // ChildExited: 是否为主动终止
+	Restarts int           // 统计窗口内已重启的次数
+	Backoff  time.Duration // ChildRestarting: 重启前的等待时间
+	Result   base.Result   // ChildGaveUp/ChildUnresponsive/ChildOverLimit: 原因
+	Output   []string      // ChildExited: 最近的输出(需SetOutputCapture)
+	Stats    *ProcStats    // ChildOverLimit: 超过阈值时的资源占用
+}
+
+// ChildEventCallback 子进程生命周期事件回调
+type ChildEventCallback func(event *ChildEvent)
+
+func (c ChildEventCallback) On(event *ChildEvent) {
+	if c != nil {
+		c(event)
+	}
+}
+
+// SetRestartPolicy 设置子进程异常退出后的自动重启策略(nil表示不重启)
+func (p *SubProcCaller) SetRestartPolicy(policy *RestartPolicy) *SubProcCaller {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.policy = policy
+	return p
+}
+
+// SetEventCallback 设置子进程生命周期事件回调
+func (p *SubProcCaller) SetEventCallback(callback ChildEventCallback) *SubProcCaller {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.onEvent = callback
+	return p
+}
+
+//////////////////////////////////// private functions
+
+// watch 等待子进程退出,并在异常退出时按策略重启
+func (p *SubProcCaller) watch(cmd *exec.Cmd, exited chan struct{}, cleanup func(), output *childOutput) {
+	cmd.Wait()
+	cleanup()
+
+	event := &ChildEvent{Type: ChildExited, Name: p.name, Pid: cmd.Process.Pid}
+	event.ExitCode, event.Signal = exitStatus(cmd.ProcessState)
+	if output != nil {
+		event.Output = output.finish()
+	}
+	close(exited)
+
+	p.mutex.Lock()
+	unexpected := p.cmd == cmd
+	if unexpected {
+		p.cmd = nil
+		p.exited = nil
+		if p.cli != nil {
+			p.cli.Close()
+			p.cli = nil
+		}
+		p.restarting = p.policy != nil
+	}
+	policy := p.policy
+	quit := p.quit
+	onEvent := p.onEvent
+	p.mutex.Unlock()
+
+	event.Expected = !unexpected
+	if unexpected {
+		logger.Warnw("[parent]child exited unexpectedly: "+p.name, nil,
+			"pid", event.Pid, "code", event.ExitCode, "signal", event.Signal)
+	} else {
+		logger.Infow("[parent]child exited: "+p.name,
+			"pid", event.Pid, "code", event.ExitCode, "signal", event.Signal)
+	}
+	onEvent.On(event)
+
+	if !unexpected {
+		return
+	}
+	if policy != nil {
+		p.restart(policy, quit, onEvent)
+	} else {
+		p.stopLoops(quit)
+	}
+}
+
+func (p *SubProcCaller) restart(policy *RestartPolicy, quit chan struct{}, onEvent ChildEventCallback) {
+	for {
+		p.mutex.Lock()
+		now := time.Now()
+		restarts := p.restartTimes[:0]
+		for _, t := range p.restartTimes {
+			if policy.Window <= 0 || now.Sub(t) < policy.Window {
+				restarts = append(restarts, t)
+			}
+		}
+		p.restartTimes = restarts
+		if policy.MaxRestarts > 0 && len(restarts) >= policy.MaxRestarts {
+			p.restarting = false
+			p.mutex.Unlock()
+			p.stopLoops(quit)
+
+			res := base.TRY_AGAIN_LATER.AppendMsg("too many restarts")
+			logger.Warnw("[parent]give up restarting child: "+p.name, res, "restarts", len(restarts))
+			onEvent.On(&ChildEvent{Type: ChildGaveUp, Name: p.name, Restarts: len(restarts), Result: res})
+			return
+		}
+		p.restartTimes = append(p.restartTimes, now)
+		count := len(p.restartTimes)
+		p.mutex.Unlock()
+
+		backoff := policy.backoff(count)
+		logger.Infow("[parent]restart child later: "+p.name, "backoff", backoff, "restarts", count)
+		onEvent.On(&ChildEvent{Type: ChildRestarting, Name: p.name, Restarts: count, Backoff: backoff})
+
+		select {
+		case <-quit:
+			return
+		case <-time.After(backoff):
+		}
+
+		res := p.launch(quit)
+		if res.IsOk() {
+			return
+		}
+		logger.Warnw("[parent]restart child failed: "+p.name, res)
+	}
+}
+
+// exitStatus 获取子进程的退出码和终止信号
+func exitStatus(state *os.ProcessState) (int, string) {
+	if state == nil {
+		return -1, ""
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return -1, status.Signal().String()
+	}
+	return state.ExitCode(), ""
+}
@@ -0,0 +1,78 @@
+package spr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCallerUnexpectedExit(t *testing.T) {
+	events := make(chan *ChildEvent, 16)
+	caller := (&SubProcCaller{}).SetHeartbeat(&HeartbeatOptions{Interval: 50 * time.Millisecond}).
+		SetEventCallback(func(event *ChildEvent) { events <- event })
+
+	for i := 0; i < 2; i++ {
+		startChild(t, caller, "child", nil)
+		caller.mutex.Lock()
+		quit := caller.quit
+		caller.mutex.Unlock()
+
+		var reply int
+		caller.CallTimeout("ctl.Exit", 1, &reply, time.Second)
+		if event := waitEvent(t, events, ChildExited); event.Expected || event.ExitCode != 1 {
+			t.Fatal("exit event:", event)
+		}
+
+		// 不重启时结束心跳协程, 可再次创建
+		select {
+		case <-quit:
+		case <-time.After(time.Second):
+			t.Fatal("heartbeat loop not stopped")
+		}
+	}
+}
+
+func TestCallerGaveUp(t *testing.T) {
+	events := make(chan *ChildEvent, 16)
+	caller := (&SubProcCaller{}).SetHeartbeat(&HeartbeatOptions{Interval: 50 * time.Millisecond}).
+		SetRestartPolicy(&RestartPolicy{InitialBackoff: 10 * time.Millisecond, MaxRestarts: 1, Window: time.Minute}).
+		SetEventCallback(func(event *ChildEvent) { events <- event })
+	startChild(t, caller, "child", nil)
+	caller.mutex.Lock()
+	quit := caller.quit
+	caller.mutex.Unlock()
+
+	var reply int
+	caller.CallTimeout("ctl.Exit", 1, &reply, time.Second)
+	waitEvent(t, events, ChildRestarting)
+	waitEvent(t, events, ChildStarted)
+	caller.CallTimeout("ctl.Exit", 1, &reply, time.Second)
+	waitEvent(t, events, ChildGaveUp)
+
+	select {
+	case <-quit:
+	case <-time.After(time.Second):
+		t.Fatal("heartbeat loop not stopped after gave up")
+	}
+}
+
+func TestSetHeartbeatCopy(t *testing.T) {
+	options := &HeartbeatOptions{}
+	caller := (&SubProcCaller{}).SetHeartbeat(options)
+	if *options != (HeartbeatOptions{}) {
+		t.Fatal("caller options modified:", options)
+	}
+	if caller.heartbeat == options || caller.heartbeat.Interval <= 0 || caller.heartbeat.MaxMisses != 1 {
+		t.Fatal("defaults:", caller.heartbeat)
+	}
+}
+
+func TestSetResourceMonitorCopy(t *testing.T) {
+	options := &ResourceMonitor{MaxThreads: 10}
+	caller := (&SubProcCaller{}).SetResourceMonitor(options)
+	if options.Interval != 0 {
+		t.Fatal("caller options modified:", options.Interval)
+	}
+	if caller.monitor == options || caller.monitor.Interval <= 0 || caller.monitor.MaxThreads != 10 {
+		t.Fatal("defaults:", caller.monitor)
+	}
+}
@@ -0,0 +1,29 @@
+//go:build linux
+
+package spr
+
+import (
+	"golang.org/x/sys/unix"
+	"os/exec"
+	"syscall"
+)
+
+func applySysProcAttr(cmd *exec.Cmd, options *LaunchOptions) {
+	if !options.Setpgid && options.Pdeathsig == 0 {
+		return
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:   options.Setpgid,
+		Pdeathsig: options.Pdeathsig,
+	}
+}
+
+func applyRlimits(pid int, rlimits []Rlimit) error {
+	for _, limit := range rlimits {
+		value := &unix.Rlimit{Cur: limit.Cur, Max: limit.Max}
+		if err := unix.Prlimit(pid, limit.Resource, value, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+//go:build linux
+
+package spr
+
+import (
+	"syscall"
+	"testing"
+)
+
+// Rlimit 子进程当前的资源限制
+func (c *TestCtl) Rlimit(resource int, reply *Rlimit) error {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(resource, &limit); err != nil {
+		return err
+	}
+	*reply = Rlimit{Resource: resource, Cur: limit.Cur, Max: limit.Max}
+	return nil
+}
+
+func TestLaunchRlimits(t *testing.T) {
+	want := Rlimit{Resource: syscall.RLIMIT_NOFILE, Cur: 200, Max: 300}
+	caller := &SubProcCaller{}
+	startChild(t, caller, "child", &LaunchOptions{Rlimits: []Rlimit{want}})
+
+	var limit Rlimit
+	if err := caller.Call("ctl.Rlimit", want.Resource, &limit); err != nil {
+		t.Fatal("Rlimit:", err)
+	}
+	// Go运行时启动时将RLIMIT_NOFILE的软限制提升到硬限制
+	if limit.Max != want.Max || limit.Cur < want.Cur {
+		t.Fatal("rlimit:", limit)
+	}
+
+	invalid := []Rlimit{{Resource: syscall.RLIMIT_NOFILE, Cur: 2, Max: 1}}
+	if res := (&SubProcCaller{}).CreateAndConnectRunnerWithOptions("invalid", "/bin/true", nil,
+		&LaunchOptions{Rlimits: invalid}); res.IsOk() {
+		t.Fatal("invalid rlimits:", res)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package spr
+
+import (
+	"errors"
+	"github.com/livekit/protocol/logger"
+	"os/exec"
+)
+
+func applySysProcAttr(cmd *exec.Cmd, options *LaunchOptions) {
+	if options.Setpgid || options.Pdeathsig != 0 {
+		logger.Warnw("[parent]Setpgid/Pdeathsig is only supported on linux", nil)
+	}
+}
+
+func applyRlimits(pid int, rlimits []Rlimit) error {
+	if len(rlimits) > 0 {
+		return errors.New("rlimits is only supported on linux")
+	}
+	return nil
+}
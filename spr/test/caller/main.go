@@ -4,6 +4,7 @@ import (
 	"github.com/patstar123/go-base"
 	"github.com/patstar123/go-base/spr"
 	"github.com/patstar123/go-base/spr/test/comm"
+	"syscall"
 	"time"
 
 	"github.com/livekit/protocol/logger"
@@ -48,13 +49,18 @@ func (p *ComplexProxy) Init(value1, value2 string, flags *comm.Flags) base.Resul
 	err := p.caller.Call("complex.Init", args, &res)
 	if err != nil {
 		logger.Warnw("lost runner", err)
-		p.caller.TerminateRunnerFastly()
 		return base.REMOTE_SYSTEM_ERROR.AppendErr("Init", err)
 	}
 	return res
 }
 
-type Simple2 struct{}
+type Simple2 struct {
+	child string
+}
+
+func (s *Simple2) BindCaller(childName string) spr.RpcSvr {
+	return &Simple2{child: childName}
+}
 
 func (s *Simple2) CustomTypeValues() []any {
 	return []any{
@@ -63,6 +69,7 @@ func (s *Simple2) CustomTypeValues() []any {
 }
 
 func (s *Simple2) Plus(args *comm.Args, reply *int) error {
+	logger.Infow("Plus called by child:", "child", s.child)
 	*reply = args.A + args.B
 	return nil
 }
@@ -75,13 +82,45 @@ func main() {
 	}
 
 	cbListener := &spr.SubProcCBListener{}
+	cbListener.SetTransport(spr.TransportUnix)
+	cbListener.Subscribe("spr_test_runner", "status", func(event *spr.Event) {
+		var status string
+		event.Decode(&status)
+		logger.Infow("child status:", "child", event.Child, "seq", event.Seq, "status", status)
+	})
+	cbListener.Subscribe("", "multiply", func(event *spr.Event) {
+		var args comm.Args
+		event.Decode(&args)
+		logger.Infow("child multiply:", "child", event.Child, "seq", event.Seq, "a", args.A, "b", args.B)
+	})
 	cbListener.StartLoop("cbListener", cbObjs)
+	defer cbListener.StopLoop()
 
 	caller := spr.SubProcCaller{}
-	res := caller.CreateAndConnectRunner("spr_test_runner", "./spr_test_runner", []spr.RunnerTyper{
+	caller.SetRestartPolicy(spr.DefaultRestartPolicy())
+	caller.SetHeartbeat(spr.DefaultHeartbeatOptions())
+	caller.SetTransport(spr.TransportUnix)
+	caller.SetOutputCapture(&spr.OutputOptions{TailLines: 10})
+	caller.SetResourceMonitor(&spr.ResourceMonitor{
+		Interval: 2 * time.Second,
+		MaxRSS:   512 * 1024 * 1024,
+		OnSample: func(stats *spr.ProcStats) {
+			logger.Debugw("child stats:", "rss", stats.RSS, "cpu", stats.CPUPercent,
+				"threads", stats.Threads, "fds", stats.FDs)
+		},
+	})
+	caller.SetEventCallback(func(event *spr.ChildEvent) {
+		logger.Infow("child event:", "type", event.Type, "name", event.Name,
+			"pid", event.Pid, "code", event.ExitCode, "signal", event.Signal, "output", len(event.Output))
+	})
+	res := caller.CreateAndConnectRunnerWithOptions("spr_test_runner", "./spr_test_runner", []spr.RunnerTyper{
 		&SimpleProxy{},
 		&ComplexProxy{},
-	}, cbListener.GetPort())
+	}, &spr.LaunchOptions{
+		CallbackListener: cbListener,
+		Args:             []string{"-mode", "test"},
+		Pdeathsig:        syscall.SIGKILL,
+	})
 	if !res.IsOk() {
 		return
 	}
@@ -121,5 +160,9 @@ func main() {
 
 	time.Sleep(5 * time.Second)
 
-	caller.TerminateRunnerSafely()
+	if stats, res := caller.RuntimeStats(); res.IsOk() {
+		logger.Infow("RuntimeStats:", "goroutines", stats.Goroutines, "heap", stats.HeapAlloc)
+	}
+
+	caller.TerminateRunnerSafely(spr.DefaultStopDeadline)
 }
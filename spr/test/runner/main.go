@@ -5,6 +5,7 @@ import (
 	"github.com/patstar123/go-base"
 	"github.com/patstar123/go-base/spr"
 	"github.com/patstar123/go-base/spr/test/comm"
+	"os"
 )
 
 type Simple struct {
@@ -18,6 +19,7 @@ func (s *Simple) CustomTypeValues() []any {
 
 func (s *Simple) Multiply(args *comm.Args, reply *int) error {
 	*reply = args.A * args.B
+	runner.Publish("multiply", args)
 	err, v := (&Simple2Callback{}).Plus(args.A, args.B)
 	if err != nil {
 		return nil
@@ -69,7 +71,18 @@ func main() {
 		"complex": &Complex{},
 	}
 
+	ctx, res := spr.ParseRunnerContext(os.Args)
+	if !res.IsOk() {
+		fmt.Println("invalid params:", res)
+		return
+	}
+	fmt.Println("custom args:", ctx.Args)
+
 	runner = &spr.SubProcRunner{}
+	runner.SetShutdownHook(func(drained bool) {
+		fmt.Println("shutdown hook, drained:", drained)
+	})
 	defer runner.StopLoop()
-	runner.Run(rpcObjs, []spr.RunnerTyper{&Simple2Callback{}})
+	runner.Publish("status", "starting")
+	runner.RunWithContext(ctx, rpcObjs, []spr.RunnerTyper{&Simple2Callback{}})
 }
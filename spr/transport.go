@@ -0,0 +1,83 @@
+package spr
+
+import (
+	"errors"
+	"github.com/patstar123/go-base"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Transport RPC传输方式
+type Transport int
+
+const (
+	TransportTCP  Transport = iota // 回环TCP端口(默认)
+	TransportUnix                  // 私有临时目录中的Unix域套接字
+)
+
+// 地址格式: TCP为端口号, 如"49152"; Unix为"unix:"+套接字路径, 如"unix:/tmp/spr-123/rpc.sock"
+const unixAddrPrefix = "unix:"
+
+// newAddress 按传输方式分配新的监听地址
+//
+//	(cleanup用于在不再使用该地址后清理资源)
+func newAddress(transport Transport, sockName string) (string, func(), base.Result) {
+	switch transport {
+	case TransportTCP:
+		port := findAvailablePort()
+		if port <= 0 {
+			return "", nil, base.INTERNAL_ERROR.AppendMsg("has no available port")
+		}
+		return strconv.Itoa(port), func() {}, base.SUCCESS
+	case TransportUnix:
+		dir, err := os.MkdirTemp("", "spr-")
+		if err != nil {
+			return "", nil, base.INTERNAL_ERROR.AppendErr("create socket dir failed", err)
+		}
+		if err = os.Chmod(dir, 0700); err != nil {
+			os.RemoveAll(dir)
+			return "", nil, base.INTERNAL_ERROR.AppendErr("chmod socket dir failed", err)
+		}
+		path := filepath.Join(dir, sockName+".sock")
+		return unixAddrPrefix + path, func() { os.RemoveAll(dir) }, base.SUCCESS
+	default:
+		return "", nil, base.INVALID_PARAM.AppendMsg("unknown transport")
+	}
+}
+
+// checkAddress 检查地址格式是否合法
+func checkAddress(addr string) error {
+	if strings.HasPrefix(addr, unixAddrPrefix) {
+		if len(addr) == len(unixAddrPrefix) {
+			return errors.New("empty socket path")
+		}
+		return nil
+	}
+	_, err := strconv.Atoi(addr)
+	return err
+}
+
+func listenAddress(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, unixAddrPrefix); ok {
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", "127.0.0.1:"+addr)
+}
+
+func dialAddress(addr string) (net.Conn, error) {
+	if path, ok := strings.CutPrefix(addr, unixAddrPrefix); ok {
+		return net.Dial("unix", path)
+	}
+	return net.Dial("tcp", "localhost:"+addr)
+}
+
+// displayAddress 用于日志输出的地址
+func displayAddress(addr string) string {
+	if path, ok := strings.CutPrefix(addr, unixAddrPrefix); ok {
+		return path
+	}
+	return "127.0.0.1:" + addr
+}
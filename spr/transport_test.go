@@ -0,0 +1,101 @@
+package spr
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// socketDir Unix域套接字地址所在的私有目录
+func socketDir(t *testing.T, addr string) string {
+	t.Helper()
+	path, ok := strings.CutPrefix(addr, unixAddrPrefix)
+	if !ok {
+		t.Fatal("not unix address:", addr)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal("socket:", err)
+	}
+	return filepath.Dir(path)
+}
+
+func checkRemoved(t *testing.T, dir string) {
+	t.Helper()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatal("socket dir not removed:", dir, err)
+	}
+}
+
+func TestCheckAddress(t *testing.T) {
+	for addr, valid := range map[string]bool{
+		"49152": true, "unix:/tmp/spr/rpc.sock": true, "unix:": false, "": false, "localhost:1": false,
+	} {
+		if err := checkAddress(addr); (err == nil) != valid {
+			t.Fatal(addr, err)
+		}
+	}
+}
+
+func TestFakeRunnerUnix(t *testing.T) {
+	fake := NewFakeRunner("fake").SetTransport(TransportUnix)
+	if res := fake.Start(map[string]RpcSvr{"svr": &TestSvr{}}, nil); !res.IsOk() {
+		t.Fatal("start:", res)
+	}
+	dir := socketDir(t, fake.ctx.RpcAddr)
+	if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0700 {
+		t.Fatal("socket dir:", err, info)
+	}
+
+	caller := &SubProcCaller{}
+	if res := fake.Attach(caller, []RunnerTyper{&TestSvr{}}); !res.IsOk() {
+		t.Fatal("attach:", res)
+	}
+	var value int
+	if err := caller.Call("svr.Multiply", &TestArgs{3, 4}, &value); err != nil || value != 12 {
+		t.Fatal("Multiply:", err, value)
+	}
+	caller.TerminateRunnerFastly()
+	fake.Stop()
+	checkRemoved(t, dir)
+}
+
+func TestChildUnix(t *testing.T) {
+	listener := (&SubProcCBListener{}).SetTransport(TransportUnix)
+	if res := listener.StartLoop(t.Name(), nil); !res.IsOk() {
+		t.Fatal("listener:", res)
+	}
+	t.Cleanup(listener.StopLoop)
+	cbDir := socketDir(t, listener.GetAddress())
+	recorder := &eventRecorder{}
+	listener.Subscribe("child", "test", recorder.handle)
+	if port := listener.GetPort(); port != -1 {
+		t.Fatal("port:", port)
+	}
+
+	events := make(chan *ChildEvent, 16)
+	caller := (&SubProcCaller{}).SetTransport(TransportUnix).
+		SetEventCallback(func(event *ChildEvent) { events <- event })
+	startChild(t, caller, "child", &LaunchOptions{CallbackListener: listener})
+	caller.mutex.Lock()
+	dir := socketDir(t, caller.rpcAddr)
+	caller.mutex.Unlock()
+
+	var pid int
+	if err := caller.Call("ctl.Pid", 0, &pid); err != nil || pid <= 0 {
+		t.Fatal("pid:", err, pid)
+	}
+	// 子进程经Unix域套接字连接回调监听器
+	if err := caller.Call("ctl.Publish", "hello", &pid); err != nil {
+		t.Fatal("publish:", err)
+	}
+	if events := recorder.wait(t, 1); len(events) != 1 || events[0].Child != "child" {
+		t.Fatal("events:", events)
+	}
+	caller.Call("ctl.Exit", 1, &pid)
+	waitEvent(t, events, ChildExited)
+	checkRemoved(t, dir)
+
+	listener.StopLoop()
+	checkRemoved(t, cbDir)
+}
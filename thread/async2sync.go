@@ -1,20 +1,25 @@
 package thread
 
 import (
+	"context"
 	"github.com/patstar123/go-base"
+	"sync"
 	"time"
 )
 
 type Sync struct {
+	mutex  sync.Mutex
 	closed bool
 	event  chan any
 }
 
 func NewSync() *Sync {
-	return &Sync{false, make(chan any, 1)}
+	return &Sync{event: make(chan any, 1)}
 }
 
 func (s *Sync) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	if !s.closed {
 		s.closed = true
 		close(s.event)
@@ -22,6 +27,8 @@ func (s *Sync) Close() {
 }
 
 func (s *Sync) Reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	if s.closed {
 		return
 	}
@@ -36,7 +43,10 @@ func (s *Sync) Wait() (base.Result, any) {
 }
 
 func (s *Sync) WaitWithTimeout(timeout time.Duration) (base.Result, any) {
-	if s.closed {
+	s.mutex.Lock()
+	closed := s.closed
+	s.mutex.Unlock()
+	if closed {
 		return base.ACTION_ILLEGAL, nil
 	}
 
@@ -44,14 +54,40 @@ func (s *Sync) WaitWithTimeout(timeout time.Duration) (base.Result, any) {
 		timeout = 0xffffffff * time.Second
 	}
 
-	t := time.After(timeout)
-	for {
-		select {
-		case <-t:
-			return base.ACTION_TIMEOUT, nil
-		case data := <-s.event:
-			return base.SUCCESS, data
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return base.ACTION_TIMEOUT, nil
+	case data, ok := <-s.event:
+		if !ok {
+			// 等待期间被关闭
+			return base.ACTION_ILLEGAL, nil
 		}
+		return base.SUCCESS, data
+	}
+}
+
+// WaitCtx 等待通知直到ctx结束
+//
+//	(ctx结束时返回ACTION_TIMEOUT/ACTION_CANCELED)
+func (s *Sync) WaitCtx(ctx context.Context) (base.Result, any) {
+	s.mutex.Lock()
+	closed := s.closed
+	s.mutex.Unlock()
+	if closed {
+		return base.ACTION_ILLEGAL, nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return base.ContextResult(ctx.Err()), nil
+	case data, ok := <-s.event:
+		if !ok {
+			// 等待期间被关闭
+			return base.ACTION_ILLEGAL, nil
+		}
+		return base.SUCCESS, data
 	}
 }
 
@@ -59,22 +95,33 @@ func (s *Sync) Notify() base.Result {
 	return s.NotifyWithData(nil)
 }
 
+// NotifyWithData 通知等待方, 已关闭(如等待超时后)时返回ACTION_ILLEGAL
 func (s *Sync) NotifyWithData(data any) base.Result {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	if s.closed {
 		return base.ACTION_ILLEGAL
 	}
-	if len(s.event) > 0 {
+
+	select {
+	case s.event <- data:
+		return base.SUCCESS
+	default:
 		return base.LOGICAL_ERROR
 	}
-
-	s.event <- data
-	return base.SUCCESS
 }
 
+// SyncByHandler 在工作队列中执行impl并等待完成
+//
+//	(在工作队列中调用时直接执行, 避免等待自身而超时; 工作队列忙时需比较协程ID, 频繁调用请使用SyncByHandlerCtx)
 func SyncByHandler(handler AsyncHandler, impl func(), timeout time.Duration) base.Result {
 	if handler == nil {
 		return base.ACTION_ILLEGAL
 	}
+	if handler.IsCurrentInWorker() {
+		impl()
+		return base.SUCCESS
+	}
 
 	if timeout < 0 {
 		timeout = 0xffffffff * time.Second
@@ -92,11 +139,47 @@ func SyncByHandler(handler AsyncHandler, impl func(), timeout time.Duration) bas
 	return res
 }
 
+// SyncByHandlerCtx 在工作队列中执行impl并等待完成, ctx结束时停止等待
+//
+//	(impl的ctx携带该工作队列的标记, ctx已携带该标记时直接执行, 不比较协程ID;
+//	 ctx在impl执行前结束时不再执行; handler实现DropAwareHandler时, impl执行前被丢弃(如工作队列退出)返回ACTION_CANCELED)
+func SyncByHandlerCtx(ctx context.Context, handler AsyncHandler, impl func(ctx context.Context)) base.Result {
+	res, _ := syncByHandlerCtx(ctx, handler, func(ctx context.Context) any {
+		impl(ctx)
+		return nil
+	}, 2)
+	return res
+}
+
+// SyncByHandlerDCtx 同SyncByHandlerCtx, 并返回impl的结果
+func SyncByHandlerDCtx(ctx context.Context, handler AsyncHandler, impl func(ctx context.Context) any) (base.Result, any) {
+	return syncByHandlerCtx(ctx, handler, impl, 2)
+}
+
+// SyncCloseByHandlerCtx 同SyncByHandlerCtx, handler为nil时直接执行impl
+func SyncCloseByHandlerCtx(ctx context.Context, handler AsyncHandler, impl func(ctx context.Context)) base.Result {
+	if handler == nil {
+		impl(ctx)
+		return base.SUCCESS
+	}
+	res, _ := syncByHandlerCtx(ctx, handler, func(ctx context.Context) any {
+		impl(ctx)
+		return nil
+	}, 2)
+	return res
+}
+
+// SyncByHandlerD 在工作队列中执行impl并等待完成, 返回impl的结果
+//
+//	(同SyncByHandler, 频繁调用请使用SyncByHandlerDCtx)
 func SyncByHandlerD(handler AsyncHandler, impl func() any,
 	timeout time.Duration) (base.Result, any) {
 	if handler == nil {
 		return base.ACTION_ILLEGAL, nil
 	}
+	if handler.IsCurrentInWorker() {
+		return base.SUCCESS, impl()
+	}
 
 	if timeout < 0 {
 		timeout = 0xffffffff * time.Second
@@ -113,6 +196,9 @@ func SyncByHandlerD(handler AsyncHandler, impl func() any,
 	return sync.WaitWithTimeout(timeout)
 }
 
+// SyncCloseByHandler 在工作队列中执行impl并等待完成, handler为nil时直接执行
+//
+//	(同SyncByHandler, 频繁调用请使用SyncCloseByHandlerCtx)
 func SyncCloseByHandler(handler AsyncHandler, impl func(), timeout time.Duration) base.Result {
 	if timeout < 0 {
 		timeout = 0xffffffff * time.Second
@@ -161,3 +247,47 @@ func SyncCall(impl func(callback base.Callback), timeout time.Duration) base.Res
 
 	return internalRes.(base.Result)
 }
+
+// syncByHandlerCtx SyncByHandlerCtx系列的实现, skip为记录投递方时往上的层数
+func syncByHandlerCtx(ctx context.Context, handler AsyncHandler, impl func(ctx context.Context) any,
+	skip int) (base.Result, any) {
+	if handler == nil {
+		return base.ACTION_ILLEGAL, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return base.ContextResult(err), nil
+	}
+	if IsInWorker(ctx, handler) {
+		return base.SUCCESS, impl(ctx)
+	}
+
+	type result struct {
+		res  base.Result
+		data any
+	}
+	sync := NewSync()
+	defer sync.Close()
+
+	cancel, res := postDropAware(handler, func() {
+		if ctx.Err() != nil {
+			return
+		}
+		data := impl(ContextWithWorker(ctx, handler))
+		sync.NotifyWithData(result{base.SUCCESS, data})
+	}, 0, func() {
+		sync.NotifyWithData(result{res: base.ACTION_CANCELED.AppendMsg("task dropped")})
+	}, callerOf(handler, skip))
+	if !res.IsOk() {
+		return res, nil
+	}
+
+	res, data := sync.WaitCtx(ctx)
+	if !res.IsOk() {
+		if cancel != nil {
+			cancel()
+		}
+		return res, nil
+	}
+	r := data.(result)
+	return r.res, r.data
+}
@@ -1,9 +1,13 @@
 package thread
 
 import (
+	"context"
 	"github.com/patstar123/go-base"
+	"github.com/patstar123/go-base/utils"
 	"time"
 
+	"go.uber.org/atomic"
+
 	"github.com/livekit/protocol/logger"
 )
 
@@ -108,6 +112,58 @@ func GoCall2(callback base.Callback, f func(), checking ...func() bool) {
 	}()
 }
 
+// AsyncCallCtx 同AsyncCall, 任务的ctx携带该工作队列的标记
+//
+//	(ctx在任务执行前结束时不再执行f, 立即以ACTION_TIMEOUT/ACTION_CANCELED回调; 投递失败时以投递结果回调;
+//	 handler实现DropAwareHandler时, 任务执行前被丢弃(如工作队列退出)以ACTION_CANCELED回调;
+//	 未实现时任务被丢弃且ctx不结束则不会回调)
+func AsyncCallCtx(ctx context.Context, caller AsyncCaller, callback base.Callback, f func(ctx context.Context),
+	checking ...func() bool) {
+	if !checkCaller(ctx, caller, callback, checking, "init first", "init first2") {
+		return
+	}
+
+	handler := caller.GetHandler()
+	postCtx(ctx, callback, handler, 0, func() {
+		if checkCaller(ctx, caller, callback, checking, "init first3", "init first4") {
+			f(ContextWithWorker(ctx, handler))
+		}
+	})
+}
+
+// AsyncCallDelayCtx 同AsyncCallDelay, 任务的ctx携带该工作队列的标记
+//
+//	(ctx在到期前结束时取消任务, 立即以ACTION_TIMEOUT/ACTION_CANCELED回调; 投递失败时以投递结果回调;
+//	 handler实现DropAwareHandler时, 执行前被丢弃(如工作队列退出)以ACTION_CANCELED回调;
+//	 未实现时任务被丢弃且ctx不结束则不会回调)
+func AsyncCallDelayCtx(ctx context.Context, caller AsyncCaller, callback base.Callback, delay time.Duration,
+	f func(ctx context.Context), checking ...func() bool) {
+	if !checkCaller(ctx, caller, callback, checking, "init first", "init first2") {
+		return
+	}
+
+	handler := caller.GetHandler()
+	postCtx(ctx, callback, handler, delay, func() {
+		if checkCaller(ctx, caller, callback, checking, "init first3", "init first4") {
+			f(ContextWithWorker(ctx, handler))
+		}
+	})
+}
+
+// GoCallCtx 同GoCall, ctx已结束时不再执行f, 以ACTION_TIMEOUT/ACTION_CANCELED回调
+func GoCallCtx(ctx context.Context, caller AsyncCaller, callback base.Callback, f func(ctx context.Context),
+	checking ...func() bool) {
+	if !checkCaller(ctx, caller, callback, checking, "init first", "init first2") {
+		return
+	}
+
+	go func() {
+		if checkCaller(ctx, caller, callback, checking, "init first3", "init first4") {
+			f(ctx)
+		}
+	}()
+}
+
 func checkAndCallback(reqCond bool, message string, callback base.Callback) bool {
 	if reqCond {
 		return true
@@ -117,3 +173,89 @@ func checkAndCallback(reqCond bool, message string, callback base.Callback) bool
 		return false
 	}
 }
+
+// checkCaller 检查ctx、caller及附加条件, 不满足时回调
+func checkCaller(ctx context.Context, caller AsyncCaller, callback base.Callback, checking []func() bool,
+	message, message2 string) bool {
+	if err := ctx.Err(); err != nil {
+		callback.On(base.ContextResult(err))
+		return false
+	}
+	if !checkAndCallback(caller.IsInited(), message, callback) {
+		return false
+	}
+	for _, c := range checking {
+		if !checkAndCallback(c(), message2, callback) {
+			return false
+		}
+	}
+	return true
+}
+
+// postCtx 向handler投递task(delay>0时延期), ctx在task执行前结束时放弃执行并立即以ContextResult回调
+//
+//	(handler实现DropAwareHandler时同时取消队列中的任务, 并在任务被丢弃(如工作队列退出)时以ACTION_CANCELED回调;
+//	 需由公开函数直接调用, 以便记录投递方)
+func postCtx(ctx context.Context, callback base.Callback, handler AsyncHandler, delay time.Duration, task func()) {
+	var claimed atomic.Bool
+	finished := make(chan struct{})
+	run := func() {
+		defer close(finished)
+		if claimed.CompareAndSwap(false, true) {
+			task()
+		}
+	}
+	onDrop := func() {
+		defer close(finished)
+		if claimed.CompareAndSwap(false, true) {
+			callback.On(base.ACTION_CANCELED.AppendMsg("task dropped"))
+		}
+	}
+
+	cancel, res := postDropAware(handler, run, delay, onDrop, callerOf(handler, 2))
+	if !res.IsOk() {
+		callback.On(res)
+		return
+	}
+	if ctx.Done() == nil {
+		return
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			if claimed.CompareAndSwap(false, true) {
+				if cancel != nil {
+					cancel()
+				}
+				callback.On(base.ContextResult(ctx.Err()))
+			}
+		case <-finished:
+		}
+	}()
+}
+
+// postDropAware 投递task(delay>0时延期), 返回的cancel可取消尚未执行的任务
+//
+//	(handler实现DropAwareHandler时task被丢弃后调用onDrop, 否则cancel为nil且不会调用onDrop; caller为记录的投递方)
+func postDropAware(handler AsyncHandler, task func(), delay time.Duration, onDrop func(),
+	caller string) (cancel func() bool, res base.Result) {
+	switch h := handler.(type) {
+	case *AsyncWorker:
+		return h.postWithDrop(&Task{f: task, onDrop: onDrop, caller: caller}, delay)
+	case DropAwareHandler:
+		return h.PostWithDrop(task, delay, onDrop)
+	}
+	if delay > 0 {
+		return nil, handler.PostDelayed(task, delay)
+	}
+	return nil, handler.Post(task)
+}
+
+// callerOf handler开启慢任务检测时, 返回调用方往上第skip层的函数名
+func callerOf(handler AsyncHandler, skip int) string {
+	if w, ok := handler.(*AsyncWorker); ok && w.slowThreshold.Load() > 0 {
+		return utils.CallerName(skip + 1)
+	}
+	return ""
+}
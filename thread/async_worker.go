@@ -2,17 +2,22 @@ package thread
 
 import (
 	"bytes"
+	"context"
 	"github.com/livekit/protocol/logger"
 	"github.com/patstar123/go-base"
 	"github.com/patstar123/go-base/utils"
 	"runtime"
+	"runtime/debug"
 	"strconv"
+	"sync"
 	"time"
+
+	"go.uber.org/atomic"
 )
 
 type AsyncHandler interface {
 	// IsCurrentInWorker 当前执行(协程)是否为工作队列本身
-	//	(该方法性能不佳,不能频繁调用)
+	//	(在任务中需频繁判断时, 可使用IsInWorker(ctx, handler))
 	IsCurrentInWorker() bool
 
 	// Post 向工作队列投递任务
@@ -22,19 +27,42 @@ type AsyncHandler interface {
 	PostDelayed(f func(), delay time.Duration) base.Result
 }
 
+// DropAwareHandler 可在任务被丢弃时通知投递方的AsyncHandler(AsyncWorker及WorkerPool均已实现)
+//
+//	(AsyncCallCtx、SyncByHandlerCtx等借此在任务不会再执行时及时结束; handler未实现时只能等待ctx结束)
+type DropAwareHandler interface {
+	AsyncHandler
+
+	// PostWithDrop 投递任务(delay>0时延期), 返回的cancel可取消尚未执行的任务
+	//	(f执行前因工作队列退出、队列已满等原因被丢弃时调用onDrop, 经cancel取消时不调用; f与onDrop至多调用其一)
+	PostWithDrop(f func(), delay time.Duration, onDrop func()) (cancel func() bool, res base.Result)
+}
+
 type AsyncWorker struct {
 	name    string
-	selfGID uint64
-	running bool
-	tasks   *taskQueue
+	selfGID atomic.Uint64
+	running atomic.Bool
+	busy    atomic.Bool // 工作队列协程是否正在执行任务
+
+	mutex   sync.Mutex
+	tasks   *taskQueue // 运行期间有效
 	maxTask int
+	onPanic PanicHandler
+
+	metrics       workerMetrics
+	slowThreshold atomic.Duration
 }
 
+// PanicHandler 处理任务中未捕获的panic, stack为panic时的调用栈
+type PanicHandler func(workerName string, r any, stack []byte)
+
 func NewAsyncWorker(ownerName string) *AsyncWorker {
-	return &AsyncWorker{"worker@" + ownerName, 0, false, nil, -1}
+	return &AsyncWorker{name: "worker@" + ownerName, maxTask: -1}
 }
 
 func (w *AsyncWorker) SetTaskMaxLimit(max int) *AsyncWorker {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
 	if w.tasks != nil {
 		logger.Warnw("AsyncWorker has been running, ignore this call(SetTaskMaxLimit)", nil)
 	} else {
@@ -43,6 +71,29 @@ func (w *AsyncWorker) SetTaskMaxLimit(max int) *AsyncWorker {
 	return w
 }
 
+// SetPanicHandler 设置任务panic时的处理函数(nil表示仅输出日志和调用栈)
+//
+//	(需在运行前设置; 任务panic后工作队列继续执行后续任务)
+func (w *AsyncWorker) SetPanicHandler(handler PanicHandler) *AsyncWorker {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.onPanic = handler
+	return w
+}
+
+// SetSlowTaskThreshold 设置慢任务的阈值, 执行时间超过阈值的任务输出警告日志(含投递方的函数名)
+//
+//	(<=0表示不检测; 开启后每次投递需额外获取调用方信息)
+func (w *AsyncWorker) SetSlowTaskThreshold(threshold time.Duration) *AsyncWorker {
+	w.slowThreshold.Store(threshold)
+	return w
+}
+
+// Name 工作队列名称
+func (w *AsyncWorker) Name() string {
+	return w.name
+}
+
 // RunInNewThreadUntilReady 创建一个新协程运行当前工作队列
 //
 //	(阻塞当前执行,直到工作队列准备就绪)
@@ -51,43 +102,51 @@ func (w *AsyncWorker) RunInNewThreadUntilReady() base.Result {
 }
 
 func (w *AsyncWorker) RunInNewThreadUntilReady2(listenPanic bool, abort bool) base.Result {
-	if w.running {
+	if w.running.Load() {
 		return base.LOGICAL_ERROR.AppendMsg("had been running worker")
 	}
 
-	sync := NewSync()
-	defer sync.Close()
+	ready := NewSync()
+	defer ready.Close()
 
 	go func() {
 		if listenPanic {
 			defer utils.ListenPanic(abort)
 		}
 
-		w.prepareLooper()
-		sync.Notify()
-		w.doLooper()
+		tasks, res := w.prepareLooper()
+		ready.NotifyWithData(res)
+		if res.IsOk() {
+			w.doLooper(tasks)
+		}
 	}()
 
-	res, _ := sync.Wait()
-	return res
+	res, data := ready.Wait()
+	if !res.IsOk() {
+		return res
+	}
+	return data.(base.Result)
 }
 
 // RunLoop 在当前协程中运行工作队列
 //
 //	(将阻塞当前执行,直到调用`stop`)
 func (w *AsyncWorker) RunLoop() base.Result {
-	if w.running {
-		return base.LOGICAL_ERROR.AppendMsg("had been running worker")
+	tasks, res := w.prepareLooper()
+	if !res.IsOk() {
+		return res
 	}
 
-	w.prepareLooper()
-	w.doLooper()
+	w.doLooper(tasks)
 	return base.SUCCESS
 }
 
 // NotifyQuit 通知工作队列退出
 func (w *AsyncWorker) NotifyQuit() base.Result {
-	w.running = false
+	w.running.Store(false)
+	if tasks := w.getTasks(); tasks != nil {
+		tasks.wakeup()
+	}
 	return base.SUCCESS
 }
 
@@ -95,75 +154,195 @@ func (w *AsyncWorker) NotifyQuit() base.Result {
 //
 //	(将阻塞当前执行,直到队列中`立即型`任务都执行完并退出)
 func (w *AsyncWorker) StopUntilQuit(timeout time.Duration) base.Result {
-	if !w.running {
+	tasks := w.getTasks()
+	if tasks == nil || !w.running.Load() {
 		return base.SUCCESS
 	}
 
 	if w.IsCurrentInWorker() {
-		w.running = false
-		return base.SUCCESS
+		return w.NotifyQuit()
 	}
 
-	sync := NewSync()
-	defer sync.Close()
+	// 放在最低优先级的队尾, 使已投递的任务都先执行
+	tasks.push(&Task{f: func() {
+		w.running.Store(false)
+	}, priority: PriorityLow}, false, true)
 
-	w.tasks.queue <- func() {
-		w.running = false
-		sync.Notify()
+	if timeout < 0 {
+		timeout = 0xffffffff * time.Second
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-tasks.stopped:
+		return base.SUCCESS
+	case <-t.C:
+		return base.ACTION_TIMEOUT
 	}
-
-	res, _ := sync.WaitWithTimeout(timeout)
-	return res
 }
 
 // IsCurrentInWorker 当前执行(协程)是否为工作队列本身
 //
-//	(该方法性能不佳,不能频繁调用)
+//	(工作队列空闲时直接返回false; 执行任务期间需比较协程ID, 频繁判断时请使用IsInWorker(ctx, w))
 func (w *AsyncWorker) IsCurrentInWorker() bool {
-	if !w.running {
+	// 工作队列协程只在执行任务时运行外部代码
+	if !w.running.Load() || !w.busy.Load() {
 		return false
 	}
 
-	return getGID() == w.selfGID
+	return getGID() == w.selfGID.Load()
+}
+
+// PostCtx 向工作队列投递任务, 任务的ctx携带该工作队列的标记
+//
+//	(任务中可通过IsInWorker(ctx, w)快速判断是否在该工作队列中执行)
+func (w *AsyncWorker) PostCtx(f func(ctx context.Context)) base.Result {
+	_, res := w.post("Post", &Task{f: func() {
+		f(ContextWithWorker(context.Background(), w))
+	}}, false)
+	return res
 }
 
 // Post 向工作队列投递任务
 func (w *AsyncWorker) Post(f func()) base.Result {
-	if w.tasks == nil || w.tasks.closed {
-		logger.Warnw("Post("+w.name+") ACTION_ILLEGAL", nil)
-		return base.ACTION_ILLEGAL
-	}
-	if len(w.tasks.queue) >= w.tasks.maxCap {
-		logger.Warnw("Post("+w.name+") full", nil)
-		return base.ACTION_CANCELED
-	}
-
-	w.tasks.queue <- f
-	return base.SUCCESS
+	_, res := w.post("Post", &Task{f: f}, false)
+	return res
 }
 
 // PostDelayed 延期向工作队列投递任务
 func (w *AsyncWorker) PostDelayed(f func(), delay time.Duration) base.Result {
-	if w.tasks == nil || w.tasks.closed {
-		logger.Warnw("PostDelayed("+w.name+") ACTION_ILLEGAL", nil)
-		return base.ACTION_ILLEGAL
+	_, res := w.postDelayed(&Task{f: f}, delay)
+	return res
+}
+
+// PostWithDrop 投递任务(delay>0时延期), 任务未执行就被丢弃时调用onDrop
+//
+//	(工作队列退出、RemoveTasks或延期任务到期时队列已满均视为丢弃; 经cancel取消时不调用onDrop)
+func (w *AsyncWorker) PostWithDrop(f func(), delay time.Duration, onDrop func()) (cancel func() bool, res base.Result) {
+	t := &Task{f: f, onDrop: onDrop}
+	if w.slowThreshold.Load() > 0 {
+		t.caller = utils.CallerName(1)
 	}
+	return w.postWithDrop(t, delay)
+}
 
-	time.AfterFunc(delay, func() {
-		w.Post(f)
-	})
+// PostTask 按优先级向工作队列投递带标签的任务, 返回的任务可被取消
+//
+//	(tag可为空, 用于RemoveTasks批量移除)
+func (w *AsyncWorker) PostTask(f func(), tag string, priority Priority) (*Task, base.Result) {
+	return w.post("Post", &Task{f: f, tag: tag, priority: priority}, false)
+}
 
-	return base.SUCCESS
+// PostAtFront 将任务投递到最高优先级队列的队首, 使其在所有待执行的任务之前执行
+func (w *AsyncWorker) PostAtFront(f func()) (*Task, base.Result) {
+	return w.post("PostAtFront", &Task{f: f, priority: PriorityHigh}, true)
+}
+
+// PostTaskDelayed 延期按优先级向工作队列投递带标签的任务, 返回的任务可被取消
+//
+//	(工作队列退出时未到期的任务被取消, 不会再执行)
+func (w *AsyncWorker) PostTaskDelayed(f func(), tag string, priority Priority, delay time.Duration) (*Task, base.Result) {
+	return w.postDelayed(&Task{f: f, tag: tag, priority: priority}, delay)
+}
+
+// RemoveTasks 取消并移除指定标签的所有待执行任务(含未到期的延期任务), 返回移除的任务数
+func (w *AsyncWorker) RemoveTasks(tag string) int {
+	tasks := w.getTasks()
+	if tasks == nil {
+		return 0
+	}
+	return tasks.removeTag(tag)
 }
 
 //////////////////////////////////// private functions
 
 const taskQueCap int = 100
 
-type taskQueue struct {
-	closed bool
-	maxCap int
-	queue  chan func()
+func (w *AsyncWorker) getTasks() *taskQueue {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.tasks
+}
+
+// post 投递任务(需由公开的投递函数直接调用, 以便记录投递方)
+func (w *AsyncWorker) post(action string, t *Task, front bool) (*Task, base.Result) {
+	if t.caller == "" && w.slowThreshold.Load() > 0 {
+		t.caller = utils.CallerName(2)
+	}
+
+	tasks := w.getTasks()
+	if tasks == nil {
+		w.metrics.rejected.Inc()
+		logger.Warnw(action+"("+w.name+") ACTION_ILLEGAL", nil)
+		return nil, base.ACTION_ILLEGAL
+	}
+
+	t.queue = tasks
+	closed, full := tasks.push(t, front, false)
+	if closed {
+		w.metrics.rejected.Inc()
+		logger.Warnw(action+"("+w.name+") ACTION_ILLEGAL", nil)
+		return nil, base.ACTION_ILLEGAL
+	}
+	if full {
+		w.metrics.rejected.Inc()
+		logger.Warnw(action+"("+w.name+") full", nil, "depth", tasks.maxCap)
+		return nil, base.ACTION_CANCELED
+	}
+	w.metrics.posted.Inc()
+	return t, base.SUCCESS
+}
+
+// postWithDrop 投递已设置onDrop的任务(已记录投递方)
+func (w *AsyncWorker) postWithDrop(t *Task, delay time.Duration) (func() bool, base.Result) {
+	var res base.Result
+	if delay > 0 {
+		t, res = w.postDelayed(t, delay)
+	} else {
+		t, res = w.post("Post", t, false)
+	}
+	if t == nil {
+		return nil, res
+	}
+	return t.Cancel, res
+}
+
+// postDelayed 延期投递任务(需由公开的投递函数直接调用, 以便记录投递方)
+func (w *AsyncWorker) postDelayed(t *Task, delay time.Duration) (*Task, base.Result) {
+	if t.caller == "" && w.slowThreshold.Load() > 0 {
+		t.caller = utils.CallerName(2)
+	}
+
+	tasks := w.getTasks()
+	if tasks == nil {
+		w.metrics.rejected.Inc()
+		logger.Warnw("PostDelayed("+w.name+") ACTION_ILLEGAL", nil)
+		return nil, base.ACTION_ILLEGAL
+	}
+
+	t.queue = tasks
+	ok := tasks.addDelayed(t, delay, func() {
+		if t.state.Load() != taskPending {
+			return
+		}
+		if closed, full := tasks.push(t, false, false); closed || full {
+			w.metrics.rejected.Inc()
+			if full {
+				logger.Warnw("PostDelayed("+w.name+") full", nil, "depth", tasks.maxCap)
+			}
+			if t.state.CompareAndSwap(taskPending, taskCanceled) {
+				t.drop()
+			}
+			return
+		}
+		w.metrics.posted.Inc()
+	})
+	if !ok {
+		w.metrics.rejected.Inc()
+		logger.Warnw("PostDelayed("+w.name+") ACTION_ILLEGAL", nil)
+		return nil, base.ACTION_ILLEGAL
+	}
+	return t, base.SUCCESS
 }
 
 func (w *AsyncWorker) waitState(running bool, waiter chan byte) base.Result {
@@ -177,7 +356,7 @@ func (w *AsyncWorker) waitState2(running bool, waiter chan byte, timeout time.Du
 		case <-t:
 			return base.ACTION_TIMEOUT
 		case <-waiter:
-			if running == w.running {
+			if running == w.running.Load() {
 				return base.SUCCESS
 			} else {
 				return base.ACTION_CANCELED
@@ -186,38 +365,96 @@ func (w *AsyncWorker) waitState2(running bool, waiter chan byte, timeout time.Du
 	}
 }
 
-func (w *AsyncWorker) prepareLooper() {
+func (w *AsyncWorker) prepareLooper() (*taskQueue, base.Result) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.tasks != nil {
+		return nil, base.LOGICAL_ERROR.AppendMsg("had been running worker")
+	}
+
 	queCap := taskQueCap
 	if w.maxTask > 0 {
 		queCap = w.maxTask
 	}
 
-	w.selfGID = getGID()
-	w.tasks = &taskQueue{false, queCap,
-		make(chan func(), queCap)}
-	w.running = true
+	w.selfGID.Store(getGID())
+	w.tasks = newTaskQueue(queCap)
+	w.running.Store(true)
+	registerWorker(w)
+	return w.tasks, base.SUCCESS
 }
 
-func (w *AsyncWorker) doLooper() {
+func (w *AsyncWorker) doLooper(tasks *taskQueue) {
 	logger.Infow("worker(" + w.name + ") started")
 
-	for w.running {
-		select {
-		case fun := <-w.tasks.queue:
-			fun()
+	w.mutex.Lock()
+	onPanic := w.onPanic
+	w.mutex.Unlock()
+
+	for w.running.Load() {
+		t := tasks.pop()
+		if t == nil {
+			<-tasks.notify
+			continue
 		}
+		w.runTask(t, onPanic)
 	}
 
-	w.running = false
-	w.selfGID = 0
-	w.tasks.closed = true
-	close(w.tasks.queue)
-	w.tasks.queue = nil
+	// 先关闭队列, 之后的投递返回ACTION_ILLEGAL
+	tasks.close()
+	w.mutex.Lock()
 	w.tasks = nil
+	w.mutex.Unlock()
+	w.running.Store(false)
+	w.selfGID.Store(0)
+	unregisterWorker(w)
 
 	logger.Infow("worker(" + w.name + ") stopped")
 }
 
+// runTask 执行任务, 任务中的panic不影响工作队列
+func (w *AsyncWorker) runTask(t *Task, onPanic PanicHandler) {
+	if !t.state.CompareAndSwap(taskPending, taskRunning) {
+		return
+	}
+	start := time.Now()
+	wait := start.Sub(t.queuedAt)
+	w.busy.Store(true)
+	defer func() {
+		w.busy.Store(false)
+		t.state.Store(taskDone)
+		if r := recover(); r != nil {
+			handlePanic(w.name, r, onPanic)
+		}
+		w.observe(t, wait, time.Since(start))
+	}()
+
+	t.f()
+}
+
+// observe 记录任务的等待及执行时间, 检测慢任务
+func (w *AsyncWorker) observe(t *Task, wait, exec time.Duration) {
+	w.metrics.executed.Inc()
+	w.metrics.waitTime.observe(wait)
+	w.metrics.execTime.observe(exec)
+
+	if threshold := w.slowThreshold.Load(); threshold > 0 && exec > threshold {
+		w.metrics.slow.Inc()
+		logger.Warnw("worker("+w.name+") slow task", nil, "caller", t.caller, "exec", exec, "wait", wait)
+	}
+}
+
+// handlePanic 处理任务中recover到的panic
+func handlePanic(name string, r any, onPanic PanicHandler) {
+	stack := debug.Stack()
+	if onPanic != nil {
+		onPanic(name, r, stack)
+	} else {
+		logger.Warnw("worker("+name+") task panic:", nil, "desc", r)
+		logger.Infow(string(stack))
+	}
+}
+
 // 下列代码源于 Brad Fitzpatrick 的 http/2 库。它被整合进了 Go 1.6 中，
 //
 //	仅仅被用于调试而非常规开发，它的性能不佳
@@ -0,0 +1,250 @@
+package thread
+
+import (
+	"context"
+	"github.com/patstar123/go-base"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func startWorker(t *testing.T) *AsyncWorker {
+	w := NewAsyncWorker(t.Name())
+	if res := w.RunInNewThreadUntilReady(); !res.IsOk() {
+		t.Fatal("run:", res)
+	}
+	t.Cleanup(func() { w.StopUntilQuit(time.Second) })
+	return w
+}
+
+// recorder 记录任务的执行顺序
+type recorder struct {
+	mutex sync.Mutex
+	order []string
+}
+
+func (r *recorder) add(name string) func() {
+	return func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.order = append(r.order, name)
+	}
+}
+
+func (r *recorder) result() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string{}, r.order...)
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestAsyncWorkerPriority(t *testing.T) {
+	w := startWorker(t)
+	r := &recorder{}
+
+	// 阻塞工作队列, 使之后的任务排队
+	block := make(chan struct{})
+	w.Post(func() { <-block })
+	w.PostTask(r.add("low"), "", PriorityLow)
+	w.Post(r.add("normal1"))
+	w.PostTask(r.add("high"), "", PriorityHigh)
+	w.Post(r.add("normal2"))
+	w.PostAtFront(r.add("front"))
+	close(block)
+
+	SyncByHandler(w, func() {}, time.Second)
+	SyncByHandler(w, func() {}, time.Second)
+	want := []string{"front", "high", "normal1", "normal2", "low"}
+	if got := r.result(); !equal(got, want) {
+		t.Fatal("order:", got)
+	}
+}
+
+func TestAsyncWorkerCancel(t *testing.T) {
+	w := startWorker(t)
+	r := &recorder{}
+
+	block := make(chan struct{})
+	w.Post(func() { <-block })
+	task1, _ := w.PostTask(r.add("task1"), "", PriorityNormal)
+	w.PostTask(r.add("task2"), "", PriorityNormal)
+	delayed, _ := w.PostTaskDelayed(r.add("delayed"), "", PriorityNormal, 50*time.Millisecond)
+	if !task1.Cancel() || !delayed.Cancel() {
+		t.Fatal("cancel failed")
+	}
+	close(block)
+
+	time.Sleep(100 * time.Millisecond)
+	if got := r.result(); !equal(got, []string{"task2"}) {
+		t.Fatal("executed:", got)
+	}
+	if task1.Cancel() || !task1.IsCanceled() {
+		t.Fatal("cancel twice")
+	}
+}
+
+func TestAsyncWorkerRemoveTasks(t *testing.T) {
+	w := startWorker(t)
+	r := &recorder{}
+
+	block := make(chan struct{})
+	w.Post(func() { <-block })
+	w.PostTask(r.add("a1"), "a", PriorityNormal)
+	w.PostTask(r.add("b1"), "b", PriorityHigh)
+	w.PostTask(r.add("a2"), "a", PriorityLow)
+	w.PostTaskDelayed(r.add("a3"), "a", PriorityNormal, 50*time.Millisecond)
+	if n := w.RemoveTasks("a"); n != 3 {
+		t.Fatal("removed:", n)
+	}
+	close(block)
+
+	time.Sleep(100 * time.Millisecond)
+	if got := r.result(); !equal(got, []string{"b1"}) {
+		t.Fatal("executed:", got)
+	}
+}
+
+func TestAsyncWorkerDelayedAfterQuit(t *testing.T) {
+	w := NewAsyncWorker(t.Name())
+	w.RunInNewThreadUntilReady()
+	r := &recorder{}
+
+	task, _ := w.PostTaskDelayed(r.add("delayed"), "", PriorityNormal, 50*time.Millisecond)
+	w.StopUntilQuit(time.Second)
+	time.Sleep(100 * time.Millisecond)
+	if got := r.result(); len(got) != 0 || !task.IsCanceled() {
+		t.Fatal("delayed task fired after quit:", got)
+	}
+}
+
+func TestAsyncWorkerPanic(t *testing.T) {
+	w := NewAsyncWorker(t.Name())
+	var recovered any
+	w.SetPanicHandler(func(workerName string, r any, stack []byte) {
+		recovered = r
+	})
+	w.RunInNewThreadUntilReady()
+	defer w.StopUntilQuit(time.Second)
+
+	w.Post(func() { panic("boom") })
+	executed := false
+	if res := SyncByHandler(w, func() { executed = true }, time.Second); !res.IsOk() || !executed {
+		t.Fatal("worker not alive after panic:", res)
+	}
+	if recovered != "boom" {
+		t.Fatal("recovered:", recovered)
+	}
+}
+
+func TestAsyncWorkerPostAfterQuit(t *testing.T) {
+	w := NewAsyncWorker(t.Name())
+	w.RunInNewThreadUntilReady()
+	if res := w.RunInNewThreadUntilReady(); res.IsOk() {
+		t.Fatal("run twice")
+	}
+
+	// 并发投递与退出
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				w.Post(func() {})
+				w.PostDelayed(func() {}, time.Millisecond)
+			}
+		}()
+	}
+	w.StopUntilQuit(time.Second)
+	wg.Wait()
+
+	if res := w.Post(func() {}); !res.IsEqual(base.ACTION_ILLEGAL) {
+		t.Fatal("post after quit:", res)
+	}
+	if _, res := w.PostTaskDelayed(func() {}, "", PriorityNormal, 0); !res.IsEqual(base.ACTION_ILLEGAL) {
+		t.Fatal("post delayed after quit:", res)
+	}
+}
+
+func TestAsyncWorkerReentrant(t *testing.T) {
+	w := startWorker(t)
+	if w.IsCurrentInWorker() {
+		t.Fatal("not in worker")
+	}
+
+	var inWorker, inCtx bool
+	var res base.Result
+	SyncByHandler(w, func() {
+		inWorker = w.IsCurrentInWorker()
+		// 在工作队列中同步调用不会等待自身
+		res = SyncByHandler(w, func() {}, time.Second)
+	}, time.Second)
+	if !inWorker || !res.IsOk() {
+		t.Fatal("reentrant:", inWorker, res)
+	}
+
+	done := make(chan struct{})
+	w.PostCtx(func(ctx context.Context) {
+		inCtx = IsInWorker(ctx, w) && !IsInWorker(ctx, NewAsyncWorker("other"))
+		close(done)
+	})
+	<-done
+	if !inCtx || IsInWorker(context.Background(), w) {
+		t.Fatal("worker context")
+	}
+}
+
+func TestAsyncWorkerMetrics(t *testing.T) {
+	w := startWorker(t).SetSlowTaskThreshold(10 * time.Millisecond)
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+	w.Post(func() {
+		close(started)
+		<-block
+		time.Sleep(20 * time.Millisecond)
+	})
+	<-started
+	task, _ := w.PostTask(func() {}, "", PriorityNormal)
+	w.PostDelayed(func() {}, time.Hour)
+	m := w.Metrics()
+	if m.QueueDepth != 1 || m.DelayedPending != 1 || m.Posted != 2 {
+		t.Fatal("metrics:", m)
+	}
+	if !strings.HasSuffix(task.caller, "TestAsyncWorkerMetrics") {
+		t.Fatal("caller:", task.caller)
+	}
+	close(block)
+
+	SyncByHandler(w, func() {}, time.Second)
+	m = w.Metrics()
+	if m.Executed != 3 || m.SlowTasks != 1 || m.ExecTime.Count != 3 || m.ExecTime.Max < 20*time.Millisecond {
+		t.Fatal("metrics:", m)
+	}
+
+	found := false
+	for _, dump := range DumpWorkers() {
+		found = found || dump.Name == w.Name()
+	}
+	w.StopUntilQuit(time.Second)
+	if !found {
+		t.Fatal("not registered")
+	}
+	for _, live := range LiveWorkers() {
+		if live == w {
+			t.Fatal("registered after quit")
+		}
+	}
+}
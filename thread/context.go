@@ -0,0 +1,23 @@
+package thread
+
+import "context"
+
+type workerKey struct{}
+
+// ContextWithWorker 返回携带工作队列标记的ctx
+//
+//	(仅应在该工作队列执行的任务中同步传递, 不要传给其他协程)
+func ContextWithWorker(ctx context.Context, handler AsyncHandler) context.Context {
+	return context.WithValue(ctx, workerKey{}, handler)
+}
+
+// IsInWorker ctx是否携带handler的工作队列标记
+//
+//	(不解析协程栈, 可频繁调用)
+func IsInWorker(ctx context.Context, handler AsyncHandler) bool {
+	if ctx == nil || handler == nil {
+		return false
+	}
+	current, _ := ctx.Value(workerKey{}).(AsyncHandler)
+	return current == handler
+}
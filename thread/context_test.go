@@ -0,0 +1,172 @@
+package thread
+
+import (
+	"context"
+	"github.com/patstar123/go-base"
+	"strings"
+	"testing"
+	"time"
+)
+
+type workerCaller struct {
+	worker *AsyncWorker
+}
+
+func (c *workerCaller) IsInited() bool           { return true }
+func (c *workerCaller) GetHandler() AsyncHandler { return c.worker }
+
+func TestSyncByHandlerCtx(t *testing.T) {
+	w := startWorker(t)
+
+	var nested base.Result
+	res := SyncByHandlerCtx(context.Background(), w, func(ctx context.Context) {
+		// 携带工作队列标记的ctx直接执行
+		nested = SyncByHandlerCtx(ctx, w, func(ctx context.Context) {})
+	})
+	if !res.IsOk() || !nested.IsOk() {
+		t.Fatal("sync:", res, nested)
+	}
+
+	block := make(chan struct{})
+	w.Post(func() { <-block })
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	executed := false
+	if res = SyncByHandlerCtx(ctx, w, func(ctx context.Context) { executed = true }); !res.IsEqual(base.ACTION_TIMEOUT) {
+		t.Fatal("timeout:", res)
+	}
+	close(block)
+	SyncByHandler(w, func() {}, time.Second)
+	if executed {
+		t.Fatal("executed after timeout")
+	}
+
+	if res, _ = NewSync().WaitCtx(ctx); !res.IsEqual(base.ACTION_TIMEOUT) {
+		t.Fatal("wait:", res)
+	}
+
+	// 执行前工作队列退出
+	block = make(chan struct{})
+	w.Post(func() { <-block })
+	done := make(chan base.Result, 1)
+	go func() {
+		done <- SyncByHandlerCtx(context.Background(), w, func(ctx context.Context) { executed = true })
+	}()
+	time.Sleep(20 * time.Millisecond)
+	w.NotifyQuit()
+	close(block)
+	select {
+	case res = <-done:
+		if !res.IsEqual(base.ACTION_CANCELED) || executed {
+			t.Fatal("stopped:", res, executed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not returned after worker stopped")
+	}
+}
+
+func TestSyncByHandlerDCtx(t *testing.T) {
+	// 单协程的工作池: 嵌套调用只能依靠ctx的标记直接执行
+	p := startPool(t, 1)
+
+	done := make(chan any, 1)
+	p.PostCtx(func(ctx context.Context) {
+		res, data := SyncByHandlerDCtx(ctx, p, func(ctx context.Context) any { return IsInWorker(ctx, p) })
+		if !res.IsOk() {
+			data = res
+		}
+		done <- data
+	})
+	select {
+	case data := <-done:
+		if data != true {
+			t.Fatal("nested:", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("nested call blocked")
+	}
+
+	res, data := SyncByHandlerDCtx(context.Background(), p, func(ctx context.Context) any { return 12 })
+	if !res.IsOk() || data != 12 {
+		t.Fatal("sync:", res, data)
+	}
+
+	executed := false
+	if res = SyncCloseByHandlerCtx(context.Background(), nil, func(ctx context.Context) { executed = true }); !res.IsOk() || !executed {
+		t.Fatal("nil handler:", res, executed)
+	}
+}
+
+func TestAsyncCallCtx(t *testing.T) {
+	w := startWorker(t)
+	caller := &workerCaller{w}
+
+	results := make(chan base.Result, 1)
+	callback := func(res base.Result) { results <- res }
+
+	AsyncCallCtx(context.Background(), caller, callback, func(ctx context.Context) {
+		callback(base.SUCCESS.SetData(IsInWorker(ctx, w)))
+	})
+	if res := <-results; !res.IsOk() || res.Data() != true {
+		t.Fatal("call:", res)
+	}
+
+	// 到期前取消
+	ctx, cancel := context.WithCancel(context.Background())
+	AsyncCallDelayCtx(ctx, caller, callback, time.Hour, func(ctx context.Context) {
+		callback(base.SUCCESS)
+	})
+	cancel()
+	select {
+	case res := <-results:
+		if !res.IsEqual(base.ACTION_CANCELED) {
+			t.Fatal("canceled:", res)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not aborted")
+	}
+
+	if m := w.Metrics(); m.DelayedPending != 0 {
+		t.Fatal("delayed task not removed:", m.DelayedPending)
+	}
+
+	// 到期前工作队列退出
+	AsyncCallDelayCtx(context.Background(), caller, callback, time.Hour, func(ctx context.Context) {
+		callback(base.SUCCESS)
+	})
+	w.StopUntilQuit(time.Second)
+	select {
+	case res := <-results:
+		if !res.IsEqual(base.ACTION_CANCELED) {
+			t.Fatal("stopped:", res)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not aborted after stop")
+	}
+
+	GoCallCtx(ctx, caller, callback, func(ctx context.Context) {
+		callback(base.SUCCESS)
+	})
+	if res := <-results; !res.IsEqual(base.ACTION_CANCELED) {
+		t.Fatal("go call:", res)
+	}
+}
+
+func TestAsyncCallCtxCaller(t *testing.T) {
+	w := startWorker(t)
+	w.SetSlowTaskThreshold(time.Second)
+
+	// 慢任务检测记录的是AsyncCallDelayCtx的调用方
+	AsyncCallDelayCtx(context.Background(), &workerCaller{w}, nil, time.Hour, func(ctx context.Context) {})
+	tasks := w.getTasks()
+	tasks.mutex.Lock()
+	defer tasks.mutex.Unlock()
+	for task := range tasks.delayed {
+		if !strings.HasSuffix(task.caller, t.Name()) {
+			t.Fatal("caller:", task.caller)
+		}
+	}
+	if len(tasks.delayed) != 1 {
+		t.Fatal("delayed:", len(tasks.delayed))
+	}
+}
@@ -0,0 +1,151 @@
+package thread
+
+import (
+	"github.com/patstar123/go-base"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronExpr 解析后的cron表达式
+//
+//	. 格式为"分 时 日 月 周", 如"*/5 9-18 * * 1-5"
+//	. 每个字段支持 *、数字、范围(a-b)、步长(*/n, a-b/n)及逗号分隔的列表
+//	. 周的取值为0-7(0和7均为周日); 日和周都被限定时, 满足其一即可
+//	. 支持@yearly(@annually)、@monthly、@weekly、@daily(@midnight)、@hourly
+type CronExpr struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron 解析cron表达式
+func ParseCron(expr string) (*CronExpr, base.Result) {
+	spec := strings.TrimSpace(expr)
+	if d, ok := cronDescriptors[spec]; ok {
+		spec = d
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, base.INVALID_PARAM.AppendMsg("invalid cron expression: " + expr)
+	}
+
+	c := &CronExpr{domAny: strings.HasPrefix(fields[2], "*"), dowAny: strings.HasPrefix(fields[4], "*")}
+	var res base.Result
+	if c.minute, res = parseCronField(fields[0], 0, 59); !res.IsOk() {
+		return nil, res
+	}
+	if c.hour, res = parseCronField(fields[1], 0, 23); !res.IsOk() {
+		return nil, res
+	}
+	if c.dom, res = parseCronField(fields[2], 1, 31); !res.IsOk() {
+		return nil, res
+	}
+	if c.month, res = parseCronField(fields[3], 1, 12); !res.IsOk() {
+		return nil, res
+	}
+	if c.dow, res = parseCronField(fields[4], 0, 7); !res.IsOk() {
+		return nil, res
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, base.SUCCESS
+}
+
+// Next 晚于t的下一个执行时间(按t的时区计算, 精确到分钟)
+//
+//	(5年内没有匹配的时间时返回零值)
+func (c *CronExpr) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !hasBit(c.month, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !hasBit(c.hour, t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if !hasBit(c.minute, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+//////////////////////////////////// private functions
+
+func (c *CronExpr) matchDay(t time.Time) bool {
+	dom := hasBit(c.dom, t.Day())
+	dow := hasBit(c.dow, int(t.Weekday()))
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func hasBit(bits uint64, v int) bool {
+	return bits&(1<<uint(v)) != 0
+}
+
+// parseCronField 解析cron表达式的一个字段, 返回取值的位图
+func parseCronField(field string, min, max int) (uint64, base.Result) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		invalid := base.INVALID_PARAM.AppendMsg("invalid cron field: " + field)
+
+		rng, step := part, 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, invalid
+			}
+			rng, step = part[:i], n
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, invalid
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, invalid
+				}
+			} else if step > 1 {
+				// "a/n"表示从a开始到最大值
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, invalid
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, base.SUCCESS
+}
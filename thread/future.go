@@ -0,0 +1,284 @@
+package thread
+
+import (
+	"github.com/patstar123/go-base"
+	"sync"
+	"time"
+)
+
+// Future 异步操作的结果(值和base.Result), 只能被完成一次
+//
+//	. 通过Complete/Resolve/Reject或Callback()完成
+//	. 通过Then/Catch/WithTimeout/All/Any组合, 继续执行的函数可投递到AsyncHandler中执行
+//	. handler为nil时, 继续执行的函数在完成Future的协程中执行
+type Future[T any] struct {
+	mutex    sync.Mutex
+	done     chan struct{}
+	value    T
+	res      base.Result
+	handlers []func()
+}
+
+func NewFuture[T any]() *Future[T] {
+	return &Future[T]{done: make(chan struct{})}
+}
+
+// Resolved 已成功完成的Future
+func Resolved[T any](value T) *Future[T] {
+	f := NewFuture[T]()
+	f.Resolve(value)
+	return f
+}
+
+// Rejected 已失败的Future
+func Rejected[T any](res base.Result) *Future[T] {
+	f := NewFuture[T]()
+	f.Reject(res)
+	return f
+}
+
+// Async 在handler中执行fn, 返回其结果的Future
+//
+//	(投递失败时Future以投递的结果失败)
+func Async[T any](handler AsyncHandler, fn func() (T, base.Result)) *Future[T] {
+	f := NewFuture[T]()
+	f.post(handler, func() { f.Complete(fn()) })
+	return f
+}
+
+// FromCallback 调用基于base.Callback的异步函数, 返回其结果的Future
+//
+//	(成功时的值取自result.Data())
+func FromCallback[T any](impl func(callback base.Callback)) *Future[T] {
+	f := NewFuture[T]()
+	impl(f.Callback())
+	return f
+}
+
+// Complete 以value和res完成, 已完成时返回false
+//
+//	(res为nil时视为base.SUCCESS)
+func (f *Future[T]) Complete(value T, res base.Result) bool {
+	if res == nil {
+		res = base.SUCCESS
+	}
+
+	f.mutex.Lock()
+	if f.res != nil {
+		f.mutex.Unlock()
+		return false
+	}
+	f.value, f.res = value, res
+	handlers := f.handlers
+	f.handlers = nil
+	close(f.done)
+	f.mutex.Unlock()
+
+	for _, h := range handlers {
+		h()
+	}
+	return true
+}
+
+// Resolve 以value成功完成
+func (f *Future[T]) Resolve(value T) bool {
+	return f.Complete(value, base.SUCCESS)
+}
+
+// Reject 以res失败完成
+func (f *Future[T]) Reject(res base.Result) bool {
+	if res == nil || res.IsOk() {
+		res = base.UNKNOWN.AppendMsg("reject with success")
+	}
+	var zero T
+	return f.Complete(zero, res)
+}
+
+// Callback 完成该Future的回调
+//
+//	(成功时的值取自result.Data(), 类型不符时以LOGICAL_ERROR失败)
+func (f *Future[T]) Callback() base.Callback {
+	return func(result base.Result) {
+		var value T
+		if result != nil && result.IsOk() && result.Data() != nil {
+			v, ok := result.Data().(T)
+			if !ok {
+				f.Reject(base.LOGICAL_ERROR.AppendMsg("unexpected callback data type"))
+				return
+			}
+			value = v
+		}
+		f.Complete(value, result)
+	}
+}
+
+// Done 完成时关闭的通道
+func (f *Future[T]) Done() <-chan struct{} {
+	return f.done
+}
+
+// IsDone 是否已完成
+func (f *Future[T]) IsDone() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Get 等待完成并返回结果
+func (f *Future[T]) Get() (T, base.Result) {
+	<-f.done
+	return f.value, f.res
+}
+
+// GetWithTimeout 等待完成并返回结果, 超时返回ACTION_TIMEOUT(timeout<0表示一直等待)
+func (f *Future[T]) GetWithTimeout(timeout time.Duration) (T, base.Result) {
+	if timeout < 0 {
+		return f.Get()
+	}
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-f.done:
+		return f.value, f.res
+	case <-t.C:
+		var zero T
+		return zero, base.ACTION_TIMEOUT
+	}
+}
+
+// OnComplete 完成后在handler中执行fn(已完成时立即投递)
+func (f *Future[T]) OnComplete(handler AsyncHandler, fn func(value T, res base.Result)) {
+	f.onDone(func() {
+		f.post(handler, func() { fn(f.value, f.res) })
+	})
+}
+
+// Catch 失败时在handler中执行fn以恢复, 成功时直接传递结果
+func (f *Future[T]) Catch(handler AsyncHandler, fn func(res base.Result) (T, base.Result)) *Future[T] {
+	next := NewFuture[T]()
+	f.onDone(func() {
+		if f.res.IsOk() {
+			next.Complete(f.value, f.res)
+			return
+		}
+		next.post(handler, func() { next.Complete(fn(f.res)) })
+	})
+	return next
+}
+
+// WithTimeout 超过timeout仍未完成时以ACTION_TIMEOUT失败
+func (f *Future[T]) WithTimeout(timeout time.Duration) *Future[T] {
+	next := NewFuture[T]()
+	t := time.AfterFunc(timeout, func() {
+		next.Reject(base.ACTION_TIMEOUT)
+	})
+	f.onDone(func() {
+		t.Stop()
+		next.Complete(f.value, f.res)
+	})
+	return next
+}
+
+// Then 成功时在handler中执行fn, 失败时直接传递失败结果
+func Then[T, U any](f *Future[T], handler AsyncHandler, fn func(value T) (U, base.Result)) *Future[U] {
+	next := NewFuture[U]()
+	f.onDone(func() {
+		if !f.res.IsOk() {
+			var zero U
+			next.Complete(zero, f.res)
+			return
+		}
+		next.post(handler, func() { next.Complete(fn(f.value)) })
+	})
+	return next
+}
+
+// All 全部成功时按顺序返回所有值, 任一失败时立即以其结果失败
+func All[T any](futures ...*Future[T]) *Future[[]T] {
+	next := NewFuture[[]T]()
+	if len(futures) == 0 {
+		next.Resolve(nil)
+		return next
+	}
+
+	var mutex sync.Mutex
+	values := make([]T, len(futures))
+	remaining := len(futures)
+	for i, f := range futures {
+		i, f := i, f
+		f.onDone(func() {
+			if !f.res.IsOk() {
+				next.Reject(f.res)
+				return
+			}
+			mutex.Lock()
+			values[i] = f.value
+			remaining--
+			finished := remaining == 0
+			mutex.Unlock()
+			if finished {
+				next.Resolve(values)
+			}
+		})
+	}
+	return next
+}
+
+// Any 返回最先成功的值, 全部失败时以最后一个失败结果失败
+func Any[T any](futures ...*Future[T]) *Future[T] {
+	next := NewFuture[T]()
+	if len(futures) == 0 {
+		next.Reject(base.INVALID_PARAM.AppendMsg("no future"))
+		return next
+	}
+
+	var mutex sync.Mutex
+	remaining := len(futures)
+	for _, f := range futures {
+		f := f
+		f.onDone(func() {
+			if f.res.IsOk() {
+				next.Resolve(f.value)
+				return
+			}
+			mutex.Lock()
+			remaining--
+			failed := remaining == 0
+			mutex.Unlock()
+			if failed {
+				next.Reject(f.res)
+			}
+		})
+	}
+	return next
+}
+
+//////////////////////////////////// private functions
+
+// onDone 完成后在完成的协程中执行fn(已完成时立即执行)
+func (f *Future[T]) onDone(fn func()) {
+	f.mutex.Lock()
+	if f.res == nil {
+		f.handlers = append(f.handlers, fn)
+		f.mutex.Unlock()
+		return
+	}
+	f.mutex.Unlock()
+	fn()
+}
+
+// post 在handler中执行fn, 投递失败时以投递结果完成f
+func (f *Future[T]) post(handler AsyncHandler, fn func()) {
+	if handler == nil {
+		fn()
+		return
+	}
+	if res := handler.Post(fn); !res.IsOk() {
+		var zero T
+		f.Complete(zero, res)
+	}
+}
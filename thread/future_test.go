@@ -0,0 +1,89 @@
+package thread
+
+import (
+	"github.com/patstar123/go-base"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFutureThen(t *testing.T) {
+	w := startWorker(t)
+
+	f := Async(w, func() (int, base.Result) { return 6, base.SUCCESS })
+	s := Then(f, w, func(v int) (string, base.Result) {
+		if !w.IsCurrentInWorker() {
+			return "", base.LOGICAL_ERROR
+		}
+		return strconv.Itoa(v * 7), base.SUCCESS
+	})
+	if v, res := s.GetWithTimeout(time.Second); !res.IsOk() || v != "42" {
+		t.Fatal("then:", v, res)
+	}
+
+	// 失败时跳过Then, 由Catch恢复
+	failed := Then(Rejected[int](base.INVALID_PARAM), nil, func(v int) (int, base.Result) {
+		t.Fatal("then after failure")
+		return 0, base.SUCCESS
+	})
+	recovered := failed.Catch(w, func(res base.Result) (int, base.Result) {
+		if !res.IsEqual(base.INVALID_PARAM) {
+			return 0, res
+		}
+		return -1, base.SUCCESS
+	})
+	if v, res := recovered.GetWithTimeout(time.Second); !res.IsOk() || v != -1 {
+		t.Fatal("catch:", v, res)
+	}
+}
+
+func TestFutureCallback(t *testing.T) {
+	f := FromCallback[string](func(callback base.Callback) {
+		go callback.OnSuccessD("data")
+	})
+	if v, res := f.GetWithTimeout(time.Second); !res.IsOk() || v != "data" {
+		t.Fatal("callback:", v, res)
+	}
+
+	f = FromCallback[string](func(callback base.Callback) {
+		callback.On(base.REMOTE_SYSTEM_ERROR)
+	})
+	if _, res := f.Get(); !res.IsEqual(base.REMOTE_SYSTEM_ERROR) {
+		t.Fatal("callback:", res)
+	}
+}
+
+func TestFutureAllAny(t *testing.T) {
+	f1, f2, f3 := NewFuture[int](), NewFuture[int](), NewFuture[int]()
+	all := All(f1, f2, f3)
+	first := Any(f1, f2, f3)
+	f2.Resolve(2)
+	if v, res := first.GetWithTimeout(time.Second); !res.IsOk() || v != 2 {
+		t.Fatal("any:", v, res)
+	}
+	f3.Resolve(3)
+	f1.Resolve(1)
+	if v, res := all.GetWithTimeout(time.Second); !res.IsOk() || len(v) != 3 || v[0] != 1 || v[2] != 3 {
+		t.Fatal("all:", v, res)
+	}
+
+	if _, res := All(Resolved(1), Rejected[int](base.ACTION_CANCELED)).Get(); !res.IsEqual(base.ACTION_CANCELED) {
+		t.Fatal("all failed:", res)
+	}
+	if _, res := Any(Rejected[int](base.ACTION_CANCELED), Rejected[int](base.ACTION_ILLEGAL)).Get(); res.IsOk() {
+		t.Fatal("any failed:", res)
+	}
+}
+
+func TestFutureWithTimeout(t *testing.T) {
+	f := NewFuture[int]()
+	if _, res := f.WithTimeout(20 * time.Millisecond).Get(); !res.IsEqual(base.ACTION_TIMEOUT) {
+		t.Fatal("timeout:", res)
+	}
+	if f.Resolve(1); !f.IsDone() || f.Resolve(2) {
+		t.Fatal("complete twice")
+	}
+	if v, _ := f.WithTimeout(time.Second).Get(); v != 1 {
+		t.Fatal("value:", v)
+	}
+}
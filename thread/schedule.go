@@ -0,0 +1,152 @@
+package thread
+
+import (
+	"github.com/patstar123/go-base"
+	"github.com/patstar123/go-base/utils"
+	"sync"
+	"time"
+)
+
+// PeriodicMode 周期任务的计时方式
+type PeriodicMode int
+
+const (
+	// FixedRate 按固定频率执行, 以计划时间计算下次执行时间, 不会累积漂移
+	//	(执行耗时超过周期时跳过错过的周期, 不补执行)
+	FixedRate PeriodicMode = iota
+	// FixedDelay 每次执行完成后间隔固定时间再执行
+	FixedDelay
+)
+
+// Schedule 周期或定时任务的句柄, 可用于停止后续的执行
+//
+//	(工作队列退出时自动停止)
+type Schedule struct {
+	worker   *AsyncWorker
+	f        func()
+	next     func() time.Time // 计算下次执行时间, 返回零值表示结束
+	caller   string
+	mutex    sync.Mutex
+	canceled bool
+	stopped  bool
+	task     *Task // 等待执行的任务
+}
+
+// Cancel 停止后续的执行, 返回是否停止成功
+//
+//	(正在执行的那次不受影响; 已停止时返回false)
+func (s *Schedule) Cancel() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.canceled || s.isStopped() {
+		return false
+	}
+	s.canceled = true
+	if s.task != nil {
+		s.task.Cancel()
+	}
+	return true
+}
+
+// IsStopped 是否已停止(被取消、工作队列退出或已没有下次执行时间)
+func (s *Schedule) IsStopped() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.canceled || s.isStopped()
+}
+
+// PostPeriodic 按固定频率(FixedRate)周期执行任务, 首次在interval后执行
+func (w *AsyncWorker) PostPeriodic(f func(), interval time.Duration) (*Schedule, base.Result) {
+	return w.postPeriodic(f, interval, FixedRate, utils.CallerName(1))
+}
+
+// PostPeriodic2 按指定的计时方式周期执行任务, 首次在interval后执行
+func (w *AsyncWorker) PostPeriodic2(f func(), interval time.Duration, mode PeriodicMode) (*Schedule, base.Result) {
+	return w.postPeriodic(f, interval, mode, utils.CallerName(1))
+}
+
+// PostCron 按cron表达式定时执行任务(使用本地时区)
+//
+//	(表达式格式见CronExpr)
+func (w *AsyncWorker) PostCron(f func(), expr string) (*Schedule, base.Result) {
+	cron, res := ParseCron(expr)
+	if !res.IsOk() {
+		return nil, res
+	}
+
+	s := &Schedule{worker: w, f: f, caller: utils.CallerName(1)}
+	s.next = func() time.Time {
+		return cron.Next(time.Now())
+	}
+	if res := s.start(); !res.IsOk() {
+		return nil, res
+	}
+	return s, base.SUCCESS
+}
+
+//////////////////////////////////// private functions
+
+func (w *AsyncWorker) postPeriodic(f func(), interval time.Duration, mode PeriodicMode, caller string) (*Schedule, base.Result) {
+	if interval <= 0 {
+		return nil, base.INVALID_PARAM.AppendMsg("invalid interval: " + interval.String())
+	}
+
+	s := &Schedule{worker: w, f: f, caller: caller}
+	if mode == FixedDelay {
+		s.next = func() time.Time {
+			return time.Now().Add(interval)
+		}
+	} else {
+		at := time.Now()
+		s.next = func() time.Time {
+			at = at.Add(interval)
+			if now := time.Now(); at.Before(now) {
+				at = at.Add((now.Sub(at)/interval + 1) * interval)
+			}
+			return at
+		}
+	}
+	if res := s.start(); !res.IsOk() {
+		return nil, res
+	}
+	return s, base.SUCCESS
+}
+
+func (s *Schedule) start() base.Result {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.arm()
+}
+
+// arm 投递下次执行的延期任务(调用时需持有锁)
+func (s *Schedule) arm() base.Result {
+	at := s.next()
+	if at.IsZero() {
+		s.task = nil
+		return base.ACTION_CANCELED.AppendMsg("no next time")
+	}
+
+	t, res := s.worker.postDelayed(&Task{f: s.run, caller: s.caller}, time.Until(at))
+	s.task = t
+	return res
+}
+
+// run 在工作队列中执行任务并投递下一次
+func (s *Schedule) run() {
+	defer func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if !s.canceled {
+			s.arm()
+		}
+	}()
+
+	s.f()
+}
+
+// isStopped 没有等待执行的任务(调用时需持有锁)
+//
+//	(工作队列退出时等待中的任务被取消)
+func (s *Schedule) isStopped() bool {
+	return s.task == nil || s.task.IsCanceled()
+}
@@ -0,0 +1,93 @@
+package thread
+
+import (
+	"github.com/patstar123/go-base"
+	"testing"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2024, 2, 28, 23, 58, 30, 0, time.UTC) // 周三
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 2, 28, 23, 59, 0, 0, time.UTC)},
+		{"*/15 9-18 * * 1-5", time.Date(2024, 2, 29, 9, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"30 6 1 * *", time.Date(2024, 3, 1, 6, 30, 0, 0, time.UTC)},
+		{"0 12 * * 0,6", time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}, // 日和周满足其一
+		{"@weekly", time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Time{}},
+	}
+	for _, c := range cases {
+		cron, res := ParseCron(c.expr)
+		if !res.IsOk() {
+			t.Fatal("parse:", c.expr, res)
+		}
+		if got := cron.Next(from); !got.Equal(c.want) {
+			t.Fatal("next:", c.expr, got)
+		}
+	}
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+		if _, res := ParseCron(expr); !res.IsEqual(base.INVALID_PARAM) {
+			t.Fatal("invalid:", expr, res)
+		}
+	}
+}
+
+func TestPostPeriodic(t *testing.T) {
+	w := startWorker(t)
+
+	var rate, delay atomic.Int32
+	fixedRate, _ := w.PostPeriodic(func() { rate.Inc() }, 10*time.Millisecond)
+	fixedDelay, _ := w.PostPeriodic2(func() {
+		delay.Inc()
+		time.Sleep(10 * time.Millisecond)
+	}, 10*time.Millisecond, FixedDelay)
+	time.Sleep(105 * time.Millisecond)
+
+	if !fixedRate.Cancel() || fixedRate.Cancel() || !fixedRate.IsStopped() {
+		t.Fatal("cancel")
+	}
+	n := rate.Load()
+	if n < 5 || n > 11 {
+		t.Fatal("fixed rate:", n)
+	}
+	if d := delay.Load(); d < 2 || d > 6 {
+		t.Fatal("fixed delay:", d)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if rate.Load() != n {
+		t.Fatal("executed after cancel")
+	}
+
+	w.StopUntilQuit(time.Second)
+	if !fixedDelay.IsStopped() {
+		t.Fatal("not stopped after quit")
+	}
+	if _, res := w.PostPeriodic(func() {}, time.Millisecond); !res.IsEqual(base.ACTION_ILLEGAL) {
+		t.Fatal("post after quit:", res)
+	}
+}
+
+func TestPostPeriodicPanic(t *testing.T) {
+	w := NewAsyncWorker(t.Name()).SetPanicHandler(func(workerName string, r any, stack []byte) {})
+	w.RunInNewThreadUntilReady()
+	defer w.StopUntilQuit(time.Second)
+
+	var count atomic.Int32
+	s, _ := w.PostPeriodic(func() {
+		count.Inc()
+		panic("boom")
+	}, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	s.Cancel()
+	if count.Load() < 2 {
+		t.Fatal("stopped after panic:", count.Load())
+	}
+}
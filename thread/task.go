@@ -0,0 +1,259 @@
+package thread
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+// Priority 任务优先级
+//
+//	(高优先级的任务先于低优先级的任务执行, 同一优先级内按投递顺序执行)
+type Priority int
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+// Task 已投递到工作队列的任务, 可用于取消尚未执行的任务
+type Task struct {
+	f        func()
+	tag      string
+	priority Priority
+	queue    *taskQueue
+	timer    *time.Timer // 延期任务的定时器
+	state    atomic.Int32
+	caller   string    // 投递方的函数名(开启慢任务检测时记录)
+	queuedAt time.Time // 进入队列的时间
+	onDrop   func()    // 未执行就被丢弃(非Cancel)时调用
+}
+
+const (
+	taskPending  int32 = iota // 等待执行(含延期等待)
+	taskRunning               // 执行中
+	taskDone                  // 已执行
+	taskCanceled              // 已取消
+)
+
+// Tag 任务标签
+func (t *Task) Tag() string {
+	return t.tag
+}
+
+// Cancel 取消尚未执行的任务, 返回是否取消成功
+//
+//	(任务已开始执行或已执行完成时返回false)
+func (t *Task) Cancel() bool {
+	if !t.state.CompareAndSwap(taskPending, taskCanceled) {
+		return false
+	}
+	t.queue.remove(t)
+	return true
+}
+
+// IsCanceled 任务是否已被取消
+func (t *Task) IsCanceled() bool {
+	return t.state.Load() == taskCanceled
+}
+
+// IsDone 任务是否已执行完成
+func (t *Task) IsDone() bool {
+	return t.state.Load() == taskDone
+}
+
+//////////////////////////////////// private functions
+
+// drop 任务已被丢弃, 通知投递方
+func (t *Task) drop() {
+	if t.onDrop != nil {
+		t.onDrop()
+	}
+}
+
+const laneCount = 3
+
+func (p Priority) lane() int {
+	switch {
+	case p > PriorityNormal:
+		return 0
+	case p < PriorityNormal:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// taskQueue 按优先级分道的任务队列
+type taskQueue struct {
+	mutex   sync.Mutex
+	closed  bool
+	maxCap  int
+	lanes   [laneCount][]*Task
+	size    int
+	delayed map[*Task]struct{} // 尚未到期的延期任务
+	notify  chan struct{}
+	stopped chan struct{} // 关闭队列(工作队列退出)时关闭
+}
+
+func newTaskQueue(maxCap int) *taskQueue {
+	return &taskQueue{maxCap: maxCap, delayed: map[*Task]struct{}{}, notify: make(chan struct{}, 1),
+		stopped: make(chan struct{})}
+}
+
+// push 将任务放入队列, force表示不受队列容量限制
+func (q *taskQueue) push(t *Task, front bool, force bool) (closed bool, full bool) {
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		return true, false
+	}
+	if !force && q.size >= q.maxCap {
+		q.mutex.Unlock()
+		return false, true
+	}
+	t.queuedAt = time.Now()
+	lane := t.priority.lane()
+	if front {
+		q.lanes[lane] = append([]*Task{t}, q.lanes[lane]...)
+	} else {
+		q.lanes[lane] = append(q.lanes[lane], t)
+	}
+	q.size++
+	q.mutex.Unlock()
+
+	q.wakeup()
+	return false, false
+}
+
+// pop 取出优先级最高的任务, 队列为空时返回nil
+func (q *taskQueue) pop() *Task {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for i := range q.lanes {
+		if len(q.lanes[i]) > 0 {
+			t := q.lanes[i][0]
+			q.lanes[i][0] = nil
+			q.lanes[i] = q.lanes[i][1:]
+			q.size--
+			return t
+		}
+	}
+	return nil
+}
+
+func (q *taskQueue) wakeup() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// addDelayed 记录延期任务并启动定时器, 到期后调用fire; 队列已关闭时返回false
+func (q *taskQueue) addDelayed(t *Task, delay time.Duration, fire func()) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		return false
+	}
+	q.delayed[t] = struct{}{}
+	t.timer = time.AfterFunc(delay, func() {
+		q.mutex.Lock()
+		delete(q.delayed, t)
+		q.mutex.Unlock()
+		fire()
+	})
+	return true
+}
+
+// depth 待执行的任务数及尚未到期的延期任务数
+func (q *taskQueue) depth() (size int, delayed int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.size, len(q.delayed)
+}
+
+// remove 从队列中移除任务
+func (q *taskQueue) remove(t *Task) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	delete(q.delayed, t)
+	lane := q.lanes[t.priority.lane()]
+	for i, s := range lane {
+		if s == t {
+			q.lanes[t.priority.lane()] = append(lane[:i], lane[i+1:]...)
+			q.size--
+			return
+		}
+	}
+}
+
+// removeTag 取消并移除指定标签的所有待执行任务, 返回移除的任务数
+func (q *taskQueue) removeTag(tag string) int {
+	q.mutex.Lock()
+	var removed []*Task
+	for i, lane := range q.lanes {
+		kept := lane[:0]
+		for _, t := range lane {
+			if t.tag == tag && t.state.CompareAndSwap(taskPending, taskCanceled) {
+				removed = append(removed, t)
+				q.size--
+			} else {
+				kept = append(kept, t)
+			}
+		}
+		for j := len(kept); j < len(lane); j++ {
+			lane[j] = nil
+		}
+		q.lanes[i] = kept
+	}
+	for t := range q.delayed {
+		if t.tag == tag && t.state.CompareAndSwap(taskPending, taskCanceled) {
+			delete(q.delayed, t)
+			removed = append(removed, t)
+		}
+	}
+	q.mutex.Unlock()
+
+	for _, t := range removed {
+		if t.timer != nil {
+			t.timer.Stop()
+		}
+		t.drop()
+	}
+	return len(removed)
+}
+
+// close 关闭队列并取消所有待执行的任务(含延期任务)
+func (q *taskQueue) close() {
+	q.mutex.Lock()
+	var pending []*Task
+	for i, lane := range q.lanes {
+		pending = append(pending, lane...)
+		q.lanes[i] = nil
+	}
+	q.size = 0
+	for t := range q.delayed {
+		pending = append(pending, t)
+	}
+	q.delayed = map[*Task]struct{}{}
+	if !q.closed {
+		q.closed = true
+		close(q.stopped)
+	}
+	q.mutex.Unlock()
+
+	for _, t := range pending {
+		if t.state.CompareAndSwap(taskPending, taskCanceled) {
+			if t.timer != nil {
+				t.timer.Stop()
+			}
+			t.drop()
+		}
+	}
+}
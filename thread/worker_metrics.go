@@ -0,0 +1,159 @@
+package thread
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+// WorkerMetrics 工作队列的统计信息
+type WorkerMetrics struct {
+	Name           string
+	Running        bool
+	Posted         uint64            // 进入队列的任务数(延期任务在到期时计入)
+	Executed       uint64            // 已执行的任务数
+	Rejected       uint64            // 因队列已满或已退出被拒绝的任务数
+	SlowTasks      uint64            // 执行时间超过阈值的任务数
+	QueueDepth     int               // 当前待执行的任务数
+	DelayedPending int               // 尚未到期的延期任务数
+	WaitTime       HistogramSnapshot // 任务从进入队列到开始执行的等待时间
+	ExecTime       HistogramSnapshot // 任务的执行时间
+}
+
+// HistogramSnapshot 耗时分布
+//
+//	(Counts[i]为耗时<=Bounds[i]的次数(不累加), 最后一项为超过所有边界的次数)
+type HistogramSnapshot struct {
+	Bounds []time.Duration
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+	Max    time.Duration
+}
+
+// Mean 平均耗时
+func (s HistogramSnapshot) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.Count)
+}
+
+// Metrics 当前的统计信息
+func (w *AsyncWorker) Metrics() WorkerMetrics {
+	m := WorkerMetrics{
+		Name:      w.name,
+		Running:   w.running.Load(),
+		Posted:    w.metrics.posted.Load(),
+		Executed:  w.metrics.executed.Load(),
+		Rejected:  w.metrics.rejected.Load(),
+		SlowTasks: w.metrics.slow.Load(),
+		WaitTime:  w.metrics.waitTime.snapshot(),
+		ExecTime:  w.metrics.execTime.snapshot(),
+	}
+	if tasks := w.getTasks(); tasks != nil {
+		m.QueueDepth, m.DelayedPending = tasks.depth()
+	}
+	return m
+}
+
+// LiveWorkers 所有运行中的工作队列, 按名称排序
+func LiveWorkers() []*AsyncWorker {
+	registry.mutex.Lock()
+	workers := make([]*AsyncWorker, 0, len(registry.workers))
+	for w := range registry.workers {
+		workers = append(workers, w)
+	}
+	registry.mutex.Unlock()
+
+	sort.Slice(workers, func(i, j int) bool {
+		return workers[i].name < workers[j].name
+	})
+	return workers
+}
+
+// DumpWorkers 所有运行中的工作队列的统计信息, 按名称排序
+func DumpWorkers() []WorkerMetrics {
+	workers := LiveWorkers()
+	metrics := make([]WorkerMetrics, 0, len(workers))
+	for _, w := range workers {
+		metrics = append(metrics, w.Metrics())
+	}
+	return metrics
+}
+
+//////////////////////////////////// private functions
+
+var registry = struct {
+	mutex   sync.Mutex
+	workers map[*AsyncWorker]struct{}
+}{workers: map[*AsyncWorker]struct{}{}}
+
+func registerWorker(w *AsyncWorker) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.workers[w] = struct{}{}
+}
+
+func unregisterWorker(w *AsyncWorker) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	delete(registry.workers, w)
+}
+
+type workerMetrics struct {
+	posted   atomic.Uint64
+	executed atomic.Uint64
+	rejected atomic.Uint64
+	slow     atomic.Uint64
+	waitTime histogram
+	execTime histogram
+}
+
+var histogramBounds = []time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// histogram 按histogramBounds分桶的耗时统计
+type histogram struct {
+	counts [7]atomic.Uint64 // len(histogramBounds)+1
+	count  atomic.Uint64
+	sum    atomic.Int64
+	max    atomic.Int64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := sort.Search(len(histogramBounds), func(i int) bool {
+		return d <= histogramBounds[i]
+	})
+	h.counts[i].Inc()
+	h.count.Inc()
+	h.sum.Add(int64(d))
+	for {
+		max := h.max.Load()
+		if int64(d) <= max || h.max.CompareAndSwap(max, int64(d)) {
+			break
+		}
+	}
+}
+
+func (h *histogram) snapshot() HistogramSnapshot {
+	s := HistogramSnapshot{
+		Bounds: append([]time.Duration{}, histogramBounds...),
+		Counts: make([]uint64, len(h.counts)),
+		Count:  h.count.Load(),
+		Sum:    time.Duration(h.sum.Load()),
+		Max:    time.Duration(h.max.Load()),
+	}
+	for i := range h.counts {
+		s.Counts[i] = h.counts[i].Load()
+	}
+	return s
+}
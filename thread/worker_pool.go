@@ -0,0 +1,487 @@
+package thread
+
+import (
+	"context"
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+// QueuePolicy 队列已满时的投递策略
+type QueuePolicy int
+
+const (
+	QueueBlock  QueuePolicy = iota // 阻塞投递方直到队列有空位(默认; 在池的协程中投递时不阻塞)
+	QueueDrop                      // 丢弃最早的未指定key的待执行任务(没有时同QueueReject)
+	QueueReject                    // 拒绝新任务, 返回ACTION_CANCELED
+)
+
+// WorkerPool 由多个协程执行任务的工作池(实现AsyncHandler)
+//
+//	. 未指定key的任务由任一空闲协程执行, 不保证顺序
+//	. 相同key的任务按投递顺序在同一协程中依次执行(如按媒体流区分)
+//	. 队列有界, 已满时按QueuePolicy处理; 可通过Resize动态调整协程数
+type WorkerPool struct {
+	name     string
+	mutex    sync.Mutex
+	cond     *sync.Cond // 通知协程有新任务
+	space    *sync.Cond // 通知投递方队列有空位
+	size     int
+	maxTask  int
+	policy   QueuePolicy
+	onPanic  PanicHandler
+	running  bool
+	shared   []poolTask          // 未指定key的任务
+	slots    []*poolSlot         // 活动的协程
+	keys     map[string]*poolKey // 有待执行任务的key
+	pending  int                 // 待执行的任务数
+	nextSlot int
+	gids     map[uint64]struct{}
+	busy     atomic.Int32           // 正在执行任务的协程数
+	timers   map[*time.Timer]func() // 未到期的延期任务, 值为丢弃时的通知(可为nil)
+	alive    int                    // 未退出的协程数(含已缩减的)
+	stopped  chan struct{}          // 停止后协程全部退出时关闭
+}
+
+func NewWorkerPool(ownerName string, size int) *WorkerPool {
+	p := &WorkerPool{
+		name:    "pool@" + ownerName,
+		size:    size,
+		maxTask: taskQueCap,
+		keys:    map[string]*poolKey{},
+		gids:    map[uint64]struct{}{},
+		timers:  map[*time.Timer]func(){},
+	}
+	p.cond = sync.NewCond(&p.mutex)
+	p.space = sync.NewCond(&p.mutex)
+	return p
+}
+
+// SetQueueLimit 设置待执行任务数的上限及队列已满时的策略
+func (p *WorkerPool) SetQueueLimit(max int, policy QueuePolicy) *WorkerPool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if max > 0 {
+		p.maxTask = max
+	}
+	p.policy = policy
+	return p
+}
+
+// SetPanicHandler 设置任务panic时的处理函数(nil表示仅输出日志和调用栈)
+func (p *WorkerPool) SetPanicHandler(handler PanicHandler) *WorkerPool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.onPanic = handler
+	return p
+}
+
+// Start 启动工作池的协程
+//
+//	(停止后可再次启动; 上次停止尚未完成时返回TRY_AGAIN_LATER)
+func (p *WorkerPool) Start() base.Result {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.running {
+		return base.LOGICAL_ERROR.AppendMsg("had been running pool")
+	}
+	if p.size <= 0 {
+		return base.INVALID_PARAM.AppendMsg("invalid pool size: " + strconv.Itoa(p.size))
+	}
+	if p.alive > 0 {
+		// 上次StopUntilQuit超时, 协程仍在执行剩余的任务
+		return base.TRY_AGAIN_LATER.AppendMsg("pool is still stopping")
+	}
+
+	p.running = true
+	p.stopped = make(chan struct{})
+	for len(p.slots) < p.size {
+		p.addSlot()
+	}
+	logger.Infow("pool(" + p.name + ") started, size: " + strconv.Itoa(p.size))
+	return base.SUCCESS
+}
+
+// Resize 调整协程数
+//
+//	(缩减的协程执行完已分配给它的key任务后退出)
+func (p *WorkerPool) Resize(size int) base.Result {
+	if size <= 0 {
+		return base.INVALID_PARAM.AppendMsg("invalid pool size: " + strconv.Itoa(size))
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.size = size
+	if !p.running {
+		return base.SUCCESS
+	}
+	for len(p.slots) < size {
+		p.addSlot()
+	}
+	for len(p.slots) > size {
+		last := p.slots[len(p.slots)-1]
+		last.retired = true
+		p.slots = p.slots[:len(p.slots)-1]
+	}
+	p.cond.Broadcast()
+	return base.SUCCESS
+}
+
+// Size 当前的协程数
+func (p *WorkerPool) Size() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.slots)
+}
+
+// StopUntilQuit 停止接受任务, 等待已投递的任务执行完成且协程全部退出
+//
+//	(未到期的延期任务被取消; 超时返回ACTION_TIMEOUT, timeout<0表示一直等待)
+func (p *WorkerPool) StopUntilQuit(timeout time.Duration) base.Result {
+	p.mutex.Lock()
+	if p.stopped == nil {
+		p.mutex.Unlock()
+		return base.SUCCESS
+	}
+	stopped := p.stopped
+	p.running = false
+	var dropped []func()
+	for t, onDrop := range p.timers {
+		if t.Stop() && onDrop != nil {
+			dropped = append(dropped, onDrop)
+		}
+	}
+	p.timers = map[*time.Timer]func(){}
+	p.cond.Broadcast()
+	p.space.Broadcast()
+	p.mutex.Unlock()
+
+	for _, onDrop := range dropped {
+		onDrop()
+	}
+
+	if p.IsCurrentInWorker() {
+		return base.SUCCESS
+	}
+
+	if timeout < 0 {
+		timeout = 0xffffffff * time.Second
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-stopped:
+		logger.Infow("pool(" + p.name + ") stopped")
+		return base.SUCCESS
+	case <-t.C:
+		return base.ACTION_TIMEOUT
+	}
+}
+
+// IsCurrentInWorker 当前执行(协程)是否为工作池的协程之一
+//
+//	(没有协程在执行任务时直接返回false; 否则需比较协程ID, 频繁判断时请使用IsInWorker(ctx, p))
+func (p *WorkerPool) IsCurrentInWorker() bool {
+	if p.busy.Load() == 0 {
+		return false
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.isWorkerLocked()
+}
+
+// PostCtx 投递任务, 任务的ctx携带该工作池的标记
+//
+//	(任务中可通过IsInWorker(ctx, p)快速判断是否在该工作池中执行)
+func (p *WorkerPool) PostCtx(f func(ctx context.Context)) base.Result {
+	return p.Post(func() {
+		f(ContextWithWorker(context.Background(), p))
+	})
+}
+
+// Post 投递任务, 由任一空闲协程执行
+func (p *WorkerPool) Post(f func()) base.Result {
+	return p.PostKeyed("", f)
+}
+
+// PostKeyed 投递任务, 相同key(非空)的任务按投递顺序在同一协程中依次执行
+func (p *WorkerPool) PostKeyed(key string, f func()) base.Result {
+	return p.postKeyed(key, poolTask{f: f})
+}
+
+// PostDelayed 延期投递任务
+//
+//	(工作池停止时未到期的任务被取消)
+func (p *WorkerPool) PostDelayed(f func(), delay time.Duration) base.Result {
+	_, res := p.postDelayed(poolTask{f: f}, delay)
+	return res
+}
+
+// PostWithDrop 投递未指定key的任务(delay>0时延期), 任务未执行就被丢弃时调用onDrop
+//
+//	(QueueDrop策略丢弃、工作池停止时未到期或到期时无法投递均视为丢弃; 经cancel取消的任务仍占用队列直到被取出)
+func (p *WorkerPool) PostWithDrop(f func(), delay time.Duration, onDrop func()) (cancel func() bool, res base.Result) {
+	var state atomic.Int32
+	task := poolTask{
+		f: func() {
+			if state.CompareAndSwap(taskPending, taskRunning) {
+				f()
+			}
+		},
+		onDrop: func() {
+			if state.CompareAndSwap(taskPending, taskCanceled) && onDrop != nil {
+				onDrop()
+			}
+		},
+	}
+	cancelTask := func() bool {
+		return state.CompareAndSwap(taskPending, taskCanceled)
+	}
+
+	if delay <= 0 {
+		if res = p.postKeyed("", task); !res.IsOk() {
+			return nil, res
+		}
+		return cancelTask, res
+	}
+
+	timer, res := p.postDelayed(task, delay)
+	if !res.IsOk() {
+		return nil, res
+	}
+	return func() bool {
+		if !cancelTask() {
+			return false
+		}
+		p.mutex.Lock()
+		delete(p.timers, timer)
+		p.mutex.Unlock()
+		timer.Stop()
+		return true
+	}, res
+}
+
+// Pending 待执行的任务数
+func (p *WorkerPool) Pending() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.pending
+}
+
+//////////////////////////////////// private functions
+
+// poolTask 工作池中的任务
+type poolTask struct {
+	f      func()
+	onDrop func() // 未执行就被丢弃时调用(可为nil)
+}
+
+func (p *WorkerPool) postKeyed(key string, task poolTask) base.Result {
+	// 在释放锁之后通知被丢弃的任务
+	var dropped []func()
+	defer func() {
+		for _, onDrop := range dropped {
+			onDrop()
+		}
+	}()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for {
+		if !p.running {
+			logger.Warnw("Post("+p.name+") ACTION_ILLEGAL", nil)
+			return base.ACTION_ILLEGAL
+		}
+		if p.pending < p.maxTask {
+			break
+		}
+		if p.policy == QueueDrop {
+			if old, ok := p.dropOldest(); ok {
+				logger.Warnw("Post("+p.name+") full, drop the oldest task", nil)
+				if old.onDrop != nil {
+					dropped = append(dropped, old.onDrop)
+				}
+				continue
+			}
+		}
+		if p.policy == QueueBlock && p.isWorkerLocked() {
+			// 在池的协程中投递时阻塞可能导致死锁
+			break
+		}
+		if p.policy != QueueBlock {
+			logger.Warnw("Post("+p.name+") full", nil)
+			return base.ACTION_CANCELED
+		}
+		p.space.Wait()
+	}
+
+	if key == "" {
+		p.shared = append(p.shared, task)
+		p.pending++
+		p.cond.Signal()
+		return base.SUCCESS
+	}
+
+	k := p.keys[key]
+	if k == nil {
+		k = &poolKey{slot: p.pickSlot()}
+		p.keys[key] = k
+	}
+	k.pending++
+	k.slot.queue = append(k.slot.queue, keyedTask{key, task.f})
+	p.pending++
+	// 唤醒所有协程以确保目标协程被唤醒
+	p.cond.Broadcast()
+	return base.SUCCESS
+}
+
+func (p *WorkerPool) postDelayed(task poolTask, delay time.Duration) (*time.Timer, base.Result) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.running {
+		logger.Warnw("PostDelayed("+p.name+") ACTION_ILLEGAL", nil)
+		return nil, base.ACTION_ILLEGAL
+	}
+
+	var t *time.Timer
+	t = time.AfterFunc(delay, func() {
+		p.mutex.Lock()
+		_, ok := p.timers[t]
+		delete(p.timers, t)
+		p.mutex.Unlock()
+		if ok {
+			if res := p.postKeyed("", task); !res.IsOk() && task.onDrop != nil {
+				task.onDrop()
+			}
+		}
+	})
+	p.timers[t] = task.onDrop
+	return t, base.SUCCESS
+}
+
+// poolSlot 工作池中的一个协程
+type poolSlot struct {
+	id      int
+	queue   []keyedTask // 分配给该协程的key任务
+	retired bool        // 已被Resize缩减, 执行完queue后退出
+}
+
+type keyedTask struct {
+	key string
+	f   func()
+}
+
+type poolKey struct {
+	slot    *poolSlot
+	pending int // 待执行及执行中的任务数
+}
+
+// addSlot 增加协程(调用时需持有锁)
+// isWorkerLocked 当前协程是否为工作池的协程之一(需持有锁; 仅在队列已满需阻塞时调用, 避免每次投递都比较协程ID)
+func (p *WorkerPool) isWorkerLocked() bool {
+	_, ok := p.gids[getGID()]
+	return ok
+}
+
+func (p *WorkerPool) addSlot() {
+	p.nextSlot++
+	slot := &poolSlot{id: p.nextSlot}
+	p.slots = append(p.slots, slot)
+	p.alive++
+	go p.loop(slot)
+}
+
+// pickSlot 为新的key选择待执行任务最少的协程(调用时需持有锁)
+func (p *WorkerPool) pickSlot() *poolSlot {
+	best := p.slots[0]
+	for _, s := range p.slots[1:] {
+		if len(s.queue) < len(best.queue) {
+			best = s
+		}
+	}
+	return best
+}
+
+// dropOldest 丢弃最早的未指定key的任务(调用时需持有锁)
+//
+//	(key任务不丢弃, 以免破坏其顺序)
+func (p *WorkerPool) dropOldest() (poolTask, bool) {
+	if len(p.shared) == 0 {
+		return poolTask{}, false
+	}
+	old := p.shared[0]
+	p.shared[0] = poolTask{}
+	p.shared = p.shared[1:]
+	p.pending--
+	return old, true
+}
+
+func (p *WorkerPool) loop(slot *poolSlot) {
+	gid := getGID()
+	p.mutex.Lock()
+	p.gids[gid] = struct{}{}
+	onPanic := p.onPanic
+	for {
+		var task keyedTask
+		if len(slot.queue) > 0 {
+			task = slot.queue[0]
+			slot.queue[0] = keyedTask{}
+			slot.queue = slot.queue[1:]
+		} else if len(p.shared) > 0 && !slot.retired {
+			task.f = p.shared[0].f
+			p.shared[0] = poolTask{}
+			p.shared = p.shared[1:]
+		} else if slot.retired || !p.running {
+			break
+		} else {
+			p.cond.Wait()
+			continue
+		}
+
+		p.pending--
+		p.space.Signal()
+		p.mutex.Unlock()
+
+		p.run(task.f, onPanic)
+
+		p.mutex.Lock()
+		if task.key != "" {
+			if k := p.keys[task.key]; k != nil {
+				k.pending--
+				if k.pending == 0 {
+					delete(p.keys, task.key)
+				}
+			}
+		}
+	}
+	delete(p.gids, gid)
+	for i, s := range p.slots {
+		if s == slot {
+			p.slots = append(p.slots[:i], p.slots[i+1:]...)
+			break
+		}
+	}
+	p.alive--
+	if p.alive == 0 && !p.running {
+		close(p.stopped)
+	}
+	p.mutex.Unlock()
+}
+
+func (p *WorkerPool) run(f func(), onPanic PanicHandler) {
+	p.busy.Inc()
+	defer func() {
+		p.busy.Dec()
+		if r := recover(); r != nil {
+			handlePanic(p.name, r, onPanic)
+		}
+	}()
+
+	f()
+}
@@ -0,0 +1,227 @@
+package thread
+
+import (
+	"context"
+	"github.com/patstar123/go-base"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+type poolCaller struct {
+	pool *WorkerPool
+}
+
+func (c *poolCaller) IsInited() bool           { return true }
+func (c *poolCaller) GetHandler() AsyncHandler { return c.pool }
+
+func startPool(t *testing.T, size int) *WorkerPool {
+	p := NewWorkerPool(t.Name(), size)
+	if res := p.Start(); !res.IsOk() {
+		t.Fatal("start:", res)
+	}
+	t.Cleanup(func() { p.StopUntilQuit(time.Second) })
+	return p
+}
+
+func TestWorkerPoolKeyed(t *testing.T) {
+	p := startPool(t, 4)
+
+	// 相同key的任务按顺序执行, 不同key的任务并发执行
+	var mutex sync.Mutex
+	orders := map[string][]int{}
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		for _, key := range []string{"a", "b", "c"} {
+			i, key := i, key
+			wg.Add(1)
+			p.PostKeyed(key, func() {
+				defer wg.Done()
+				mutex.Lock()
+				orders[key] = append(orders[key], i)
+				mutex.Unlock()
+			})
+		}
+		if i == 50 {
+			p.Resize(2)
+		}
+	}
+	wg.Wait()
+
+	for key, order := range orders {
+		for i, v := range order {
+			if v != i {
+				t.Fatal("out of order:", key, order)
+			}
+		}
+	}
+	if p.Size() != 2 {
+		t.Fatal("size:", p.Size())
+	}
+}
+
+func TestWorkerPoolPolicy(t *testing.T) {
+	for _, policy := range []QueuePolicy{QueueReject, QueueDrop} {
+		p := NewWorkerPool(t.Name()+strconv.Itoa(int(policy)), 1).SetQueueLimit(2, policy)
+		p.Start()
+
+		block := make(chan struct{})
+		started := make(chan struct{})
+		p.Post(func() {
+			close(started)
+			<-block
+		})
+		<-started
+
+		var executed atomic.Int32
+		for i := 0; i < 2; i++ {
+			p.Post(func() { executed.Inc() })
+		}
+		res := p.Post(func() { executed.Inc() })
+		switch policy {
+		case QueueReject:
+			if !res.IsEqual(base.ACTION_CANCELED) {
+				t.Fatal("reject:", res)
+			}
+		case QueueDrop:
+			if !res.IsOk() || p.Pending() != 2 {
+				t.Fatal("drop:", res, p.Pending())
+			}
+		}
+		close(block)
+		p.StopUntilQuit(time.Second)
+		if executed.Load() != 2 {
+			t.Fatal("executed:", executed.Load())
+		}
+		if res = p.Post(func() {}); !res.IsEqual(base.ACTION_ILLEGAL) {
+			t.Fatal("post after stop:", res)
+		}
+	}
+}
+
+func TestWorkerPoolBlock(t *testing.T) {
+	p := NewWorkerPool(t.Name(), 1).SetQueueLimit(1, QueueBlock)
+	p.Start()
+	defer p.StopUntilQuit(time.Second)
+
+	block := make(chan struct{})
+	p.Post(func() { <-block })
+	p.Post(func() {})
+
+	posted := make(chan base.Result, 1)
+	go func() { posted <- p.Post(func() {}) }()
+	select {
+	case <-posted:
+		t.Fatal("not blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(block)
+	if res := <-posted; !res.IsOk() {
+		t.Fatal("post:", res)
+	}
+}
+
+func TestWorkerPoolAsyncCall(t *testing.T) {
+	p := startPool(t, 2)
+
+	done := make(chan bool, 1)
+	AsyncCall(&poolCaller{p}, nil, func() { done <- p.IsCurrentInWorker() })
+	if !<-done {
+		t.Fatal("not in pool")
+	}
+	if p.IsCurrentInWorker() {
+		t.Fatal("in pool")
+	}
+
+	var executed bool
+	if res := SyncByHandler(p, func() { executed = true }, time.Second); !res.IsOk() || !executed {
+		t.Fatal("sync:", res)
+	}
+}
+
+func TestWorkerPoolRestart(t *testing.T) {
+	p := NewWorkerPool(t.Name(), 2)
+	for i := 0; i < 2; i++ {
+		if res := p.Start(); !res.IsOk() {
+			t.Fatal("start:", i, res)
+		}
+		done := make(chan struct{})
+		if res := p.PostKeyed("key", func() { close(done) }); !res.IsOk() {
+			t.Fatal("post:", i, res)
+		}
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("task lost after restart:", i)
+		}
+		p.StopUntilQuit(-1)
+		if p.Size() != 0 || p.Pending() != 0 {
+			t.Fatal("not reset:", p.Size(), p.Pending())
+		}
+	}
+
+	// 上次停止超时, 协程仍在执行任务
+	p.Start()
+	block := make(chan struct{})
+	p.Post(func() { <-block })
+	if res := p.StopUntilQuit(10 * time.Millisecond); !res.IsEqual(base.ACTION_TIMEOUT) {
+		t.Fatal("stop:", res)
+	}
+	if res := p.Start(); !res.IsEqual(base.TRY_AGAIN_LATER) {
+		t.Fatal("start while stopping:", res)
+	}
+	close(block)
+	p.StopUntilQuit(time.Second)
+	if res := p.Start(); !res.IsOk() {
+		t.Fatal("start after stopped:", res)
+	}
+	p.StopUntilQuit(time.Second)
+}
+
+func TestWorkerPoolAsyncCallCtx(t *testing.T) {
+	p := NewWorkerPool(t.Name(), 1).SetQueueLimit(1, QueueDrop)
+	p.Start()
+	caller := &poolCaller{p}
+	results := make(chan base.Result, 2)
+	callback := func(res base.Result) { results <- res }
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+	p.Post(func() {
+		close(started)
+		<-block
+	})
+	<-started
+
+	// 队列已满时被丢弃
+	AsyncCallCtx(context.Background(), caller, callback, func(ctx context.Context) {
+		callback(base.SUCCESS)
+	})
+	p.Post(func() {})
+	select {
+	case res := <-results:
+		if !res.IsEqual(base.ACTION_CANCELED) {
+			t.Fatal("dropped:", res)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no callback after dropped")
+	}
+	close(block)
+
+	// 到期前工作池停止
+	AsyncCallDelayCtx(context.Background(), caller, callback, time.Hour, func(ctx context.Context) {
+		callback(base.SUCCESS)
+	})
+	p.StopUntilQuit(time.Second)
+	select {
+	case res := <-results:
+		if !res.IsEqual(base.ACTION_CANCELED) {
+			t.Fatal("stopped:", res)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no callback after stopped")
+	}
+}
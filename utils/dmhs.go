@@ -26,7 +26,7 @@ func NewDMHS(ctx context.Context, rdb redis.UniversalClient,
 	}
 
 	return &DMHS{
-		randomSecret: randomSecret(),
+		randomSecret: RandomSecret(),
 		ctx:          ctx,
 		rdb:          rdb,
 		instanceName: instanceName,
@@ -140,7 +140,8 @@ func (d *DMHS) getOnlineInstance() (error, string, time.Time) {
 	return nil, values[0].(string), GetUnmarshalTime(values[1])
 }
 
-func randomSecret() string {
+// RandomSecret 生成256位随机密钥(base62编码)
+func RandomSecret() string {
 	// 256 bit secret
 	buf := make([]byte, 32)
 	_, err := io.ReadFull(rand.Reader, buf)